@@ -0,0 +1,67 @@
+package generator
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// ackedCursor is the entity saved at the key of ForEachAcked.
+type ackedCursor struct {
+	Cursor string `datastore:",noindex"`
+}
+
+// ForEachAcked calls fn with the entities of every chunk in the query order,
+// and saves the end cursor of the chunk at key after fn succeeds.  If the
+// cursor has been saved, it resumes from there instead of StartCursor in o, so
+// the chunk that fn has not acked is processed again after a crash.  It stops
+// when fn returns an error, and returns that error or the first error in the
+// stream.
+func ForEachAcked(ctx context.Context, o *Options, key *datastore.Key, fn func(ctx context.Context, entities []interface{}) error) error {
+	if o == nil {
+		return errors.New("Options is nil")
+	}
+	if key == nil {
+		return errors.New("key is not set")
+	}
+
+	var acked ackedCursor
+	if err := datastore.Get(ctx, key, &acked); err != nil && err != datastore.ErrNoSuchEntity {
+		return errors.Wrap(err, "error in Get")
+	}
+
+	oc := *o
+	oc.PreserveOrder = true
+	if acked.Cursor != "" {
+		oc.Offset = 0
+		oc.StartCursor = acked.Cursor
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	ch := New(ctx, &oc)
+	defer func() {
+		cancel()
+		Drain(ch)
+	}()
+
+	for unit := range ch {
+		if unit.Err != nil {
+			return errors.WithStack(unit.Err)
+		}
+		if unit.Cursor == "" {
+			continue
+		}
+
+		if len(unit.Entities) > 0 {
+			if err := fn(ctx, unit.Entities); err != nil {
+				return errors.Wrap(err, "error in fn")
+			}
+		}
+
+		if _, err := datastore.Put(ctx, key, &ackedCursor{Cursor: unit.Cursor}); err != nil {
+			return errors.Wrap(err, "error in Put")
+		}
+	}
+
+	return nil
+}
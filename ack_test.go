@@ -0,0 +1,89 @@
+package generator
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestForEachAcked(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	o := &Options{
+		Appender:               appender,
+		ChunkSize:              chunkSize,
+		IgnoreErrFieldMismatch: true,
+		ParentKey:              parentKey,
+		Query:                  datastore.NewQuery("testHoge").Ancestor(parentKey),
+	}
+	key := datastore.NewKey(ctx, "testCheckpoint", "hoge", 0, nil)
+
+	// it crashes in the third chunk before the ack.
+	processed := map[int64]int{}
+	var crashed []int64
+	chunks := 0
+	crash := errors.New("crash")
+	err = ForEachAcked(ctx, o, key, func(ctx context.Context, entities []interface{}) error {
+		chunks++
+		for _, e := range entities {
+			id := e.(*testHoge).ID
+			if chunks == 3 {
+				crashed = append(crashed, id)
+				continue
+			}
+			processed[id]++
+		}
+		if chunks == 3 {
+			return crash
+		}
+		return nil
+	})
+	if errors.Cause(err) != crash {
+		t.Fatalf("error differs => expected: %v, result: %+v", crash, err)
+	}
+
+	// the crashed chunk is processed again first.
+	first := true
+	if err := ForEachAcked(ctx, o, key, func(ctx context.Context, entities []interface{}) error {
+		if first {
+			first = false
+			if id := entities[0].(*testHoge).ID; id != crashed[0] {
+				t.Fatalf("chunk does not resume from the crash => expected: %d, result: %d", crashed[0], id)
+			}
+		}
+		for _, e := range entities {
+			processed[e.(*testHoge).ID]++
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("error in ForEachAcked: %+v", err)
+	}
+
+	if len(processed) != allHoges {
+		t.Fatalf("number differs => expected: %d, result: %d", allHoges, len(processed))
+	}
+	for id, n := range processed {
+		if n != 1 {
+			t.Fatalf("entity %d is processed %d times", id, n)
+		}
+	}
+
+	// everything has been acked.
+	if err := ForEachAcked(ctx, o, key, func(ctx context.Context, entities []interface{}) error {
+		t.Fatalf("acked entities are processed again: %d", len(entities))
+		return nil
+	}); err != nil {
+		t.Fatalf("error in ForEachAcked: %+v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package generator
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/appengine"
+)
+
+// adaptiveTargetLatency is the latency of GetMulti over which AdaptiveFetch
+// makes chunks smaller.  This can be replaced in tests.
+var adaptiveTargetLatency = 500 * time.Millisecond
+
+// adaptiveDivisor divides ChunkSize into the smallest chunk size of
+// AdaptiveFetch, which is also the step to grow.
+const adaptiveDivisor = 10
+
+// fetchFeedback is the result of GetMulti for a chunk that getMulti sends
+// back to query for AdaptiveFetch.
+type fetchFeedback struct {
+	latency time.Duration
+	failed  bool
+}
+
+// newFetchFeedback returns the feedback of GetMulti that took latency and
+// returned err.  MultiErrors are of entities, so they do not count as
+// failures.
+func newFetchFeedback(latency time.Duration, err error) fetchFeedback {
+	_, multi := errors.Cause(err).(appengine.MultiError)
+	return fetchFeedback{latency: latency, failed: err != nil && !multi}
+}
+
+// adaptiveSize is the chunk size of AdaptiveFetch.  It grows by step while
+// GetMulti is fast, and halves when it is slow or fails, as the congestion
+// control of TCP does.
+type adaptiveSize struct {
+	size, step, max int
+	feedback        chan fetchFeedback
+}
+
+func newAdaptiveSize(max int) *adaptiveSize {
+	step := max / adaptiveDivisor
+	if step < 1 {
+		step = 1
+	}
+	return &adaptiveSize{
+		size: step,
+		step: step,
+		max:  max,
+		// feedback is dropped if this is full.
+		feedback: make(chan fetchFeedback, adaptiveDivisor),
+	}
+}
+
+// next returns the size for the next chunk after the feedback so far.
+func (a *adaptiveSize) next() int {
+	for {
+		select {
+		case f := <-a.feedback:
+			if f.failed || f.latency > adaptiveTargetLatency {
+				a.size /= 2
+				if a.size < a.step {
+					a.size = a.step
+				}
+			} else if a.size += a.step; a.size > a.max {
+				a.size = a.max
+			}
+		default:
+			return a.size
+		}
+	}
+}
+
+// sendFeedback sends the feedback without blocking.
+func sendFeedback(feedback chan<- fetchFeedback, f fetchFeedback) {
+	select {
+	case feedback <- f:
+	default:
+	}
+}
@@ -0,0 +1,85 @@
+package generator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestAdaptiveSize(t *testing.T) {
+	a := newAdaptiveSize(20)
+
+	fast := newFetchFeedback(time.Millisecond, nil)
+	slow := newFetchFeedback(adaptiveTargetLatency*2, nil)
+	failed := newFetchFeedback(time.Millisecond, errors.New("some error"))
+
+	for _, c := range []struct {
+		feedback []fetchFeedback
+		expected int
+	}{
+		{nil, 2},
+		{[]fetchFeedback{fast}, 4},
+		{[]fetchFeedback{fast, fast, fast, fast, fast, fast, fast, fast, fast}, 20},
+		{[]fetchFeedback{failed}, 10},
+		{[]fetchFeedback{slow}, 5},
+		{[]fetchFeedback{slow, slow}, 2},
+	} {
+		for _, f := range c.feedback {
+			sendFeedback(a.feedback, f)
+		}
+		if size := a.next(); size != c.expected {
+			t.Fatalf("size differs => expected: %d, result: %d", c.expected, size)
+		}
+	}
+
+	// MultiError is not a failure of GetMulti.
+	if f := newFetchFeedback(time.Millisecond, appengine.MultiError{datastore.ErrNoSuchEntity}); f.failed {
+		t.Fatalf("MultiError is a failure")
+	}
+}
+
+func TestAdaptiveFetch(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	var sizes []int
+	for unit := range New(ctx, &Options{
+		AdaptiveFetch:          true,
+		Appender:               appender,
+		ChunkSize:              chunkSize * 2,
+		IgnoreErrFieldMismatch: true,
+		ParentKey:              parentKey,
+		PreserveOrder:          true,
+		Query:                  datastore.NewQuery("testHoge").Ancestor(parentKey),
+	}) {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		sizes = append(sizes, len(unit.Entities))
+	}
+
+	total := 0
+	for _, n := range sizes {
+		if n > chunkSize*2 {
+			t.Fatalf("chunk is larger than ChunkSize: %d", n)
+		}
+		total += n
+	}
+	if total != allHoges {
+		t.Fatalf("number differs => expected: %d, result: %d", allHoges, total)
+	}
+	if sizes[0] > 2 {
+		t.Fatalf("first chunk is not small: %d", sizes[0])
+	}
+}
@@ -0,0 +1,51 @@
+package generator
+
+import (
+	"reflect"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// entityAppender returns an Appender that makes a new entity of t for each key.
+// t can be a struct or a pointer to it.
+func entityAppender(t reflect.Type) Appender {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	return func(ctx context.Context, entities []interface{}, i int, k *datastore.Key, parentKey *datastore.Key) []interface{} {
+		v := reflect.New(t)
+		setKeyFields(v.Elem(), k)
+		return append(entities, v.Interface())
+	}
+}
+
+// setKeyFields sets the fields of the struct v for k by goon tags.
+func setKeyFields(v reflect.Value, k *datastore.Key) {
+	if v.Kind() != reflect.Struct {
+		return
+	}
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := v.Field(i)
+		if !f.CanSet() {
+			continue
+		}
+		switch strings.Split(t.Field(i).Tag.Get("goon"), ",")[0] {
+		case "id":
+			switch f.Kind() {
+			case reflect.Int64:
+				f.SetInt(k.IntID())
+			case reflect.String:
+				f.SetString(k.StringID())
+			}
+		case "parent":
+			if f.Type() == reflect.TypeOf(k) {
+				f.Set(reflect.ValueOf(k.Parent()))
+			}
+		}
+	}
+}
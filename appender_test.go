@@ -0,0 +1,75 @@
+package generator
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mjibson/goon"
+	"google.golang.org/appengine/datastore"
+)
+
+type testNamedHoge struct {
+	ID   string `datastore:"-" goon:"id"`
+	Name string
+}
+
+func TestEntityType(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	count := 0
+	for unit := range New(ctx, &Options{
+		ChunkSize:  chunkSize,
+		EntityType: reflect.TypeOf(testHoge{}),
+		Query:      datastore.NewQuery("testHoge").Ancestor(parentKey).Filter("Name =", "Fuga Hogeo"),
+	}) {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		for _, e := range unit.Entities {
+			h := e.(*testHoge)
+			if h.ID == 0 || !h.Parent.Equal(parentKey) || h.Name != "Fuga Hogeo" {
+				t.Fatalf("entity differs: %+v", h)
+			}
+			count++
+		}
+	}
+	if count != allFugas {
+		t.Fatalf("number differs => expected: %d, result: %d", allFugas, count)
+	}
+
+	names := []string{"hoge", "fuga", "piyo"}
+	for _, n := range names {
+		if _, err := goon.FromContext(ctx).Put(&testNamedHoge{ID: n, Name: n}); err != nil {
+			t.Fatalf("error in Put: %+v", err)
+		}
+	}
+
+	count = 0
+	for unit := range New(ctx, &Options{
+		ChunkSize:  chunkSize,
+		EntityType: reflect.TypeOf(&testNamedHoge{}),
+		Query:      datastore.NewQuery("testNamedHoge"),
+	}) {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		for _, e := range unit.Entities {
+			if h := e.(*testNamedHoge); h.ID == "" || h.ID != h.Name {
+				t.Fatalf("entity differs: %+v", h)
+			}
+			count++
+		}
+	}
+	if count != len(names) {
+		t.Fatalf("number differs => expected: %d, result: %d", len(names), count)
+	}
+}
@@ -0,0 +1,48 @@
+package generator
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// Batch runs the generator and calls fn with every n entities regardless of
+// ChunkSize.  The last batch may have fewer entities.  It stops when fn
+// returns an error, and returns that error or the first error in the stream.
+func Batch(ctx context.Context, o *Options, n int, fn func(ctx context.Context, batch []interface{}) error) error {
+	if n <= 0 {
+		return errors.Errorf("invalid batch size: %d", n)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	ch := New(ctx, o)
+	defer func() {
+		cancel()
+		Drain(ch)
+	}()
+
+	batch := make([]interface{}, 0, n)
+	for unit := range ch {
+		if unit.Err != nil {
+			return errors.WithStack(unit.Err)
+		}
+
+		for _, e := range unit.Entities {
+			batch = append(batch, e)
+			if len(batch) < n {
+				continue
+			}
+			if err := fn(ctx, batch); err != nil {
+				return errors.Wrap(err, "error in fn")
+			}
+			batch = make([]interface{}, 0, n)
+		}
+	}
+
+	if len(batch) > 0 {
+		if err := fn(ctx, batch); err != nil {
+			return errors.Wrap(err, "error in fn")
+		}
+	}
+
+	return nil
+}
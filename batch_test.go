@@ -0,0 +1,70 @@
+package generator
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestBatch(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	var sizes []int
+	if err := Batch(ctx, &Options{
+		Appender:  appender,
+		ChunkSize: chunkSize,
+		ParentKey: parentKey,
+		Query:     datastore.NewQuery("testHoge").Ancestor(parentKey).Filter("Name =", "Fuga Hogeo"),
+	}, 7, func(ctx context.Context, batch []interface{}) error {
+		sizes = append(sizes, len(batch))
+		return nil
+	}); err != nil {
+		t.Fatalf("error in Batch: %+v", err)
+	}
+
+	expected := []int{7, 7, 7, 7, 4}
+	if !reflect.DeepEqual(sizes, expected) {
+		t.Fatalf("sizes differ => expected: %v, result: %v", expected, sizes)
+	}
+}
+
+func TestBatchStopsByError(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	someErr := errors.New("hoge error")
+	calls := 0
+	err = Batch(ctx, &Options{
+		Appender:  appender,
+		ChunkSize: chunkSize,
+		ParentKey: parentKey,
+		Query:     datastore.NewQuery("testHoge").Ancestor(parentKey).Filter("Name =", "Fuga Hogeo"),
+	}, 7, func(ctx context.Context, batch []interface{}) error {
+		calls++
+		return someErr
+	})
+
+	if errors.Cause(err) != someErr || calls != 1 {
+		t.Fatalf("Batch does not stop => calls: %d, err: %+v", calls, err)
+	}
+}
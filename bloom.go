@@ -0,0 +1,99 @@
+package generator
+
+import (
+	"hash/fnv"
+	"math"
+	"sync"
+)
+
+// KeyFilter tells whether the encoded key is in the set.
+type KeyFilter interface {
+	Contains(key string) bool
+}
+
+// BloomFilter is a KeyFilter that uses fixed memory for any number of keys.
+// Contains may return true for keys that have not been added, at the rate
+// given to NewBloomFilter.  It is safe for concurrent use.
+type BloomFilter struct {
+	mu     sync.Mutex
+	bits   []uint64
+	m      uint64
+	hashes int
+}
+
+// NewBloomFilter returns a BloomFilter for n keys with the false positive rate
+// fpRate.
+func NewBloomFilter(n int, fpRate float64) *BloomFilter {
+	if n < 1 {
+		n = 1
+	}
+	if fpRate <= 0 || fpRate >= 1 {
+		fpRate = 0.01
+	}
+
+	m := uint64(math.Ceil(-float64(n) * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	hashes := int(math.Ceil(float64(m) / float64(n) * math.Ln2))
+	if hashes < 1 {
+		hashes = 1
+	}
+
+	return &BloomFilter{
+		bits:   make([]uint64, (m+63)/64),
+		m:      m,
+		hashes: hashes,
+	}
+}
+
+// Add adds the encoded key.
+func (b *BloomFilter) Add(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.add(key)
+}
+
+func (b *BloomFilter) add(key string) {
+	h1, h2 := bloomHash(key)
+	for i := 0; i < b.hashes; i++ {
+		n := (h1 + uint64(i)*h2) % b.m
+		b.bits[n/64] |= 1 << (n % 64)
+	}
+}
+
+// Contains returns true if the encoded key may have been added.
+func (b *BloomFilter) Contains(key string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.contains(key)
+}
+
+func (b *BloomFilter) contains(key string) bool {
+	h1, h2 := bloomHash(key)
+	for i := 0; i < b.hashes; i++ {
+		n := (h1 + uint64(i)*h2) % b.m
+		if b.bits[n/64]&(1<<(n%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *BloomFilter) claim(key string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.contains(key) {
+		return false
+	}
+	b.add(key)
+	return true
+}
+
+func bloomHash(key string) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	// the second hash must not be 0 to probe different bits.
+	return sum & 0xffffffff, sum>>32 | 1
+}
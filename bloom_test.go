@@ -0,0 +1,101 @@
+package generator
+
+import (
+	"fmt"
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+)
+
+func TestBloomFilter(t *testing.T) {
+	const n = 1000
+	b := NewBloomFilter(n, 0.01)
+
+	for i := 0; i < n; i++ {
+		b.Add(fmt.Sprintf("added-%d", i))
+	}
+
+	for i := 0; i < n; i++ {
+		if key := fmt.Sprintf("added-%d", i); !b.Contains(key) {
+			t.Fatalf("%s is not contained", key)
+		}
+	}
+
+	fp := 0
+	for i := 0; i < n; i++ {
+		if b.Contains(fmt.Sprintf("other-%d", i)) {
+			fp++
+		}
+	}
+	if fp > n/20 {
+		t.Fatalf("too many false positives: %d", fp)
+	}
+}
+
+func TestExcludeFilter(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	q := datastore.NewQuery("testHoge").Ancestor(parentKey)
+	keys, err := q.KeysOnly().GetAll(ctx, nil)
+	if err != nil {
+		t.Fatalf("error in GetAll: %+v", err)
+	}
+
+	b := NewBloomFilter(len(keys), 0.01)
+	excluded := map[int64]bool{}
+	for i, k := range keys {
+		if i%2 == 0 {
+			b.Add(k.Encode())
+			excluded[k.IntID()] = true
+		}
+	}
+
+	o := &Options{
+		Appender:               appender,
+		ChunkSize:              chunkSize,
+		IgnoreErrFieldMismatch: true,
+		ParentKey:              parentKey,
+		Query:                  q,
+	}
+
+	// testOldHoge is not yielded, so count the expected ones without the filter.
+	expected := 0
+	for unit := range New(ctx, o) {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		for _, e := range unit.Entities {
+			if h := e.(*testHoge); !excluded[h.ID] {
+				expected++
+			}
+		}
+	}
+
+	o.ExcludeFilter = b
+	count := 0
+	for unit := range New(ctx, o) {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		for _, e := range unit.Entities {
+			if h := e.(*testHoge); excluded[h.ID] {
+				t.Fatalf("excluded entity is yielded: %+v", h)
+			}
+			count++
+		}
+	}
+
+	// false positives may skip a few more.
+	if count > expected || count < expected-3 {
+		t.Fatalf("number differs => expected: %d, result: %d", expected, count)
+	}
+}
@@ -0,0 +1,105 @@
+package generator
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+// ErrCircuitOpen is returned instead of calling the datastore while
+// CircuitBreaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitBreaker stops calling the datastore after failures in a row.  It can
+// be shared by many generators.  datastore.Done and MultiError do not count as
+// failures because they are not errors of the datastore itself.
+type CircuitBreaker struct {
+	// FailureThreshold is the number of failures in a row to open the
+	// circuit.  The default value is 5.
+	FailureThreshold int
+	// Cooldown is the duration the circuit keeps open.  After that, it
+	// allows one call to test the recovery.  If the call succeeds, the circuit
+	// is closed, or it is opened again.  The default value is 10 seconds.
+	Cooldown time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+	now      func() time.Time
+}
+
+const (
+	defaultFailureThreshold = 5
+	defaultCooldown         = 10 * time.Second
+)
+
+// call calls f unless the circuit is open.  cb can be nil.
+func (cb *CircuitBreaker) call(f func() error) error {
+	if cb == nil {
+		return f()
+	}
+
+	if !cb.allow() {
+		return ErrCircuitOpen
+	}
+
+	err := f()
+	cb.record(err)
+
+	return err
+}
+
+func (cb *CircuitBreaker) allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.openedAt.IsZero() {
+		return true
+	}
+
+	cooldown := cb.Cooldown
+	if cooldown <= 0 {
+		cooldown = defaultCooldown
+	}
+	if cb.probing || cb.clock().Sub(cb.openedAt) < cooldown {
+		return false
+	}
+
+	// half-open: only this call tests the recovery.
+	cb.probing = true
+	return true
+}
+
+func (cb *CircuitBreaker) record(err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if _, ok := err.(appengine.MultiError); err == nil || ok || err == datastore.Done {
+		cb.failures = 0
+		cb.openedAt = time.Time{}
+		cb.probing = false
+		return
+	}
+
+	threshold := cb.FailureThreshold
+	if threshold <= 0 {
+		threshold = defaultFailureThreshold
+	}
+
+	cb.failures++
+	if cb.probing || cb.failures >= threshold {
+		cb.openedAt = cb.clock()
+		cb.probing = false
+	}
+}
+
+func (cb *CircuitBreaker) clock() time.Time {
+	if cb.now != nil {
+		return cb.now()
+	}
+	return time.Now()
+}
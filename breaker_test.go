@@ -0,0 +1,88 @@
+package generator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Date(2017, 5, 23, 0, 0, 0, 0, time.UTC)
+	cb := &CircuitBreaker{
+		FailureThreshold: 3,
+		Cooldown:         time.Minute,
+		now:              func() time.Time { return now },
+	}
+
+	calls := 0
+	hogeErr := errors.New("hoge error")
+	failing := func() error {
+		calls++
+		return hogeErr
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := cb.call(failing); err != hogeErr {
+			t.Fatalf("err differs: %+v", err)
+		}
+	}
+
+	for i := 0; i < 5; i++ {
+		if err := cb.call(failing); err != ErrCircuitOpen {
+			t.Fatalf("circuit is not open: %+v", err)
+		}
+	}
+	if calls != 3 {
+		t.Fatalf("backend is called while open: %d", calls)
+	}
+
+	// the test call after the cooldown fails, so it opens again.
+	now = now.Add(time.Minute)
+	if err := cb.call(failing); err != hogeErr {
+		t.Fatalf("circuit is not half-open: %+v", err)
+	}
+	if err := cb.call(failing); err != ErrCircuitOpen {
+		t.Fatalf("circuit is not open again: %+v", err)
+	}
+	if calls != 4 {
+		t.Fatalf("backend is called while open: %d", calls)
+	}
+
+	// the test call succeeds, so it is closed.
+	now = now.Add(time.Minute)
+	if err := cb.call(func() error { return nil }); err != nil {
+		t.Fatalf("error in call: %+v", err)
+	}
+	if err := cb.call(failing); err != hogeErr {
+		t.Fatalf("circuit is not closed: %+v", err)
+	}
+}
+
+func TestCircuitBreakerIgnoresNonFailures(t *testing.T) {
+	cb := &CircuitBreaker{FailureThreshold: 1}
+
+	calls := 0
+	for _, err := range []error{datastore.Done, appengine.MultiError{datastore.ErrNoSuchEntity}} {
+		for i := 0; i < 3; i++ {
+			if result := cb.call(func() error { calls++; return err }); result == ErrCircuitOpen {
+				t.Fatalf("circuit is open by %v", err)
+			}
+		}
+	}
+	if calls != 6 {
+		t.Fatalf("backend is not called: %d", calls)
+	}
+}
+
+func TestNilCircuitBreaker(t *testing.T) {
+	var cb *CircuitBreaker
+	hogeErr := errors.New("hoge error")
+	for i := 0; i < 10; i++ {
+		if err := cb.call(func() error { return hogeErr }); err != hogeErr {
+			t.Fatalf("err differs: %+v", err)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package generator
+
+import (
+	"golang.org/x/net/context"
+)
+
+// bucketize buffers entities up to BucketWindow and yields a Unit for each
+// bucket made by TimeBucket.  Errors and the other Units without entities are
+// yielded after the buffered entities.
+func bucketize(ctx context.Context, in <-chan Unit, o *Options) <-chan Unit {
+	out := make(chan Unit)
+
+	window := o.BucketWindow
+	if window <= 0 {
+		window = o.ChunkSize
+	}
+
+	go func() {
+		defer close(out)
+
+		var order []string
+		buckets := make(map[string]*Unit)
+		buffered := 0
+
+		flush := func() {
+			for _, b := range order {
+				out <- *buckets[b]
+			}
+			order = nil
+			buckets = make(map[string]*Unit)
+			buffered = 0
+		}
+
+		for u := range in {
+			if u.Err != nil || u.Kind != UnitData {
+				flush()
+				out <- u
+				continue
+			}
+
+			for i, e := range u.Entities {
+				b := o.TimeBucket(e)
+				bu, ok := buckets[b]
+				if !ok {
+					bu = &Unit{Bucket: b}
+					buckets[b] = bu
+					order = append(order, b)
+				}
+				bu.Entities = append(bu.Entities, e)
+				if i < len(u.Keys) {
+					bu.Keys = append(bu.Keys, u.Keys[i])
+				}
+				if i < len(u.Kinds) {
+					bu.Kinds = append(bu.Kinds, u.Kinds[i])
+				}
+				buffered++
+				if buffered >= window {
+					flush()
+				}
+			}
+		}
+
+		flush()
+	}()
+
+	return out
+}
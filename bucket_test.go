@@ -0,0 +1,55 @@
+package generator
+
+import (
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+)
+
+func TestTimeBucket(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	bucket := func(e interface{}) string {
+		if e.(*testHoge).ID%2 == 0 {
+			return "even"
+		}
+		return "odd"
+	}
+
+	count := 0
+	for unit := range New(ctx, &Options{
+		Appender:               appender,
+		BucketWindow:           20,
+		ChunkSize:              chunkSize,
+		IgnoreErrFieldMismatch: true,
+		ParentKey:              parentKey,
+		Query:                  datastore.NewQuery("testHoge").Ancestor(parentKey),
+		TimeBucket:             bucket,
+	}) {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		if unit.Bucket == "" || len(unit.Entities) == 0 {
+			t.Fatalf("unit is invalid: %+v", unit)
+		}
+		for _, e := range unit.Entities {
+			if b := bucket(e); b != unit.Bucket {
+				t.Fatalf("bucket differs => unit: %s, entity: %s", unit.Bucket, b)
+			}
+			count++
+		}
+	}
+
+	if count != allHoges {
+		t.Fatalf("number differs => expected: %d, result: %d", allHoges, count)
+	}
+}
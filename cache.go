@@ -0,0 +1,44 @@
+package generator
+
+import (
+	"sync"
+
+	"github.com/mjibson/goon"
+)
+
+// flushLocalCache flushes the local cache of g.  This can be replaced in
+// tests.
+var flushLocalCache = func(g *goon.Goon) {
+	g.FlushLocalCache()
+}
+
+// cacheFlusher flushes the local cache of Goon every max entities loaded.
+type cacheFlusher struct {
+	max    int
+	loaded int
+
+	mu sync.Mutex
+}
+
+// newCacheFlusher returns nil if max is not positive.
+func newCacheFlusher(max int) *cacheFlusher {
+	if max <= 0 {
+		return nil
+	}
+	return &cacheFlusher{max: max}
+}
+
+// add counts n entities loaded by g, and flushes the local cache of g if max
+// entities have been loaded since the last flush.  f can be nil.
+func (f *cacheFlusher) add(g *goon.Goon, n int) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.loaded += n
+	if f.loaded >= f.max {
+		f.loaded = 0
+		flushLocalCache(g)
+	}
+}
@@ -0,0 +1,51 @@
+package generator
+
+import (
+	"testing"
+
+	"github.com/mjibson/goon"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestMaxLocalCacheEntries(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	orig := flushLocalCache
+	defer func() { flushLocalCache = orig }()
+	g := goon.FromContext(ctx)
+	flushes := 0
+	flushLocalCache = func(fg *goon.Goon) {
+		if fg != g {
+			t.Errorf("another Goon is flushed")
+		}
+		flushes++
+		orig(fg)
+	}
+
+	// 55 entities are loaded in chunks of 10, so flushed at 20 and 40.
+	if err := testFetch(ctx, allHoges, &Options{
+		Appender:               appender,
+		ChunkSize:              chunkSize,
+		Goon:                   g,
+		IgnoreErrFieldMismatch: true,
+		MaxConcurrency:         1,
+		MaxLocalCacheEntries:   2 * chunkSize,
+		ParentKey:              parentKey,
+		Query:                  datastore.NewQuery("testHoge").Ancestor(parentKey),
+	}); err != nil {
+		t.Fatalf("error in testFetch: %+v", err)
+	}
+
+	if flushes != 2 {
+		t.Fatalf("number of flushes differs => expected: 2, result: %d", flushes)
+	}
+}
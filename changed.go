@@ -0,0 +1,64 @@
+package generator
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/mjibson/goon"
+	"github.com/pkg/errors"
+	"google.golang.org/appengine/datastore"
+)
+
+// ContentHash returns the hash of the properties that the entity saves to the
+// datastore.  It is the hash that ChangedSince in Options compares.
+func ContentHash(e interface{}) (string, error) {
+	var props []datastore.Property
+	var err error
+	if pls, ok := e.(datastore.PropertyLoadSaver); ok {
+		props, err = pls.Save()
+	} else {
+		props, err = datastore.SaveStruct(e)
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "error in saving properties")
+	}
+
+	h := sha1.New()
+	for _, p := range props {
+		fmt.Fprintf(h, "%s\x00%T\x00%v\x00", p.Name, p.Value, p.Value)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// changedSince returns entities that are new or changed from the prior
+// snapshot, and the keys of them in keys.
+func changedSince(g *goon.Goon, entities []interface{}, keys []*datastore.Key, prior func(key string) (string, bool)) ([]interface{}, []*datastore.Key, error) {
+	changed := make([]interface{}, 0, len(entities))
+	var changedKeys []*datastore.Key
+	for i, e := range entities {
+		k, err := g.KeyError(e)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "error in KeyError")
+		}
+
+		priorHash, known := prior(k.Encode())
+		if known {
+			hash, err := ContentHash(e)
+			if err != nil {
+				return nil, nil, err
+			}
+			if hash == priorHash {
+				continue
+			}
+		}
+
+		changed = append(changed, e)
+		if i < len(keys) {
+			changedKeys = append(changedKeys, keys[i])
+		}
+	}
+
+	return changed, changedKeys, nil
+}
@@ -0,0 +1,102 @@
+package generator
+
+import (
+	"testing"
+
+	"github.com/mjibson/goon"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestContentHash(t *testing.T) {
+	h1, err := ContentHash(&testHoge{ID: 1, Name: "Hoge Fugao"})
+	if err != nil {
+		t.Fatalf("error in ContentHash: %+v", err)
+	}
+	h2, err := ContentHash(&testHoge{ID: 1, Name: "Hoge Fugao"})
+	if err != nil {
+		t.Fatalf("error in ContentHash: %+v", err)
+	}
+	h3, err := ContentHash(&testHoge{ID: 1, Name: "Fuga Hogeo"})
+	if err != nil {
+		t.Fatalf("error in ContentHash: %+v", err)
+	}
+
+	if h1 != h2 {
+		t.Fatalf("hash differs for the same content => %s, %s", h1, h2)
+	}
+	if h1 == h3 {
+		t.Fatalf("hash is the same for different content: %s", h1)
+	}
+}
+
+func TestChangedSince(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	o := &Options{
+		Appender:               appender,
+		ChunkSize:              chunkSize,
+		IgnoreErrFieldMismatch: true,
+		ParentKey:              parentKey,
+		Query:                  datastore.NewQuery("testHoge").Ancestor(parentKey),
+	}
+
+	// the prior snapshot has the current hash for a half, a stale hash for a
+	// quarter, and nothing for the rest.
+	g := goon.FromContext(ctx)
+	prior := map[string]string{}
+	expected := map[int64]bool{}
+	i := 0
+	for unit := range New(ctx, o) {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		for _, e := range unit.Entities {
+			h := e.(*testHoge)
+			hash, err := ContentHash(h)
+			if err != nil {
+				t.Fatalf("error in ContentHash: %+v", err)
+			}
+			switch i % 4 {
+			case 0, 1:
+				prior[g.Key(h).Encode()] = hash
+			case 2:
+				prior[g.Key(h).Encode()] = "stale"
+				expected[h.ID] = true
+			default:
+				expected[h.ID] = true
+			}
+			i++
+		}
+	}
+
+	o.ChangedSince = func(key string) (string, bool) {
+		hash, ok := prior[key]
+		return hash, ok
+	}
+
+	count := 0
+	for unit := range New(ctx, o) {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		for _, e := range unit.Entities {
+			if h := e.(*testHoge); !expected[h.ID] {
+				t.Fatalf("unchanged entity is yielded: %+v", h)
+			}
+			count++
+		}
+	}
+
+	if count != len(expected) {
+		t.Fatalf("number differs => expected: %d, result: %d", len(expected), count)
+	}
+}
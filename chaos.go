@@ -0,0 +1,94 @@
+package generator
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// ErrChaos is the error that Chaos injects.  It is retried by default.
+var ErrChaos = errors.New("error injected by Chaos")
+
+// Chaos injects errors and latency into the scan and GetMulti of each chunk,
+// to test retries, timeouts and fallbacks.  Whether a call fails and how long
+// it waits are decided by Seed, the chunk and the attempt, so the same Seed
+// perturbs the same calls in every run regardless of concurrency.
+type Chaos struct {
+	// ErrorRate is the rate of calls that fail with ErrChaos, from 0 to 1.
+	ErrorRate float64
+	// LatencyMean is the mean of the latency added to each call.  The
+	// latency is exponentially distributed.  No latency is added if this is
+	// zero.
+	LatencyMean time.Duration
+	// Seed is the seed of the perturbations.
+	Seed int64
+}
+
+const (
+	chaosScan = iota
+	chaosLoad
+)
+
+// perturb waits for the latency and returns ErrChaos if the call of op for the
+// attempt of the chunk fails.  c can be nil.
+func (c *Chaos) perturb(ctx context.Context, op, index, attempt int) error {
+	if c == nil {
+		return nil
+	}
+
+	if c.LatencyMean > 0 {
+		u := c.random(op, index, attempt, 1)
+		latency := time.Duration(-math.Log(1-u) * float64(c.LatencyMean))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(latency):
+		}
+	}
+
+	if c.random(op, index, attempt, 0) < c.ErrorRate {
+		return ErrChaos
+	}
+	return nil
+}
+
+// random returns a number in [0, 1) made from Seed and the arguments.
+func (c *Chaos) random(args ...int) float64 {
+	h := fnv.New64a()
+	binary.Write(h, binary.LittleEndian, c.Seed)
+	for _, a := range args {
+		binary.Write(h, binary.LittleEndian, int64(a))
+	}
+	return float64(h.Sum64()>>11) / (1 << 53)
+}
+
+// chaosIterator fails the first Next with the error of Chaos.
+type chaosIterator struct {
+	iterator
+	err error
+}
+
+func (t *chaosIterator) Next(dst interface{}) (*datastore.Key, error) {
+	if err := t.err; err != nil {
+		t.err = nil
+		return nil, err
+	}
+	return t.iterator.Next(dst)
+}
+
+// iterator perturbs the scan of the attempt of the chunk.  The latency is
+// added before it returns, and the error is returned by the first Next.
+func (c *Chaos) iterator(ctx context.Context, t iterator, index, attempt int) iterator {
+	if c == nil {
+		return t
+	}
+	if err := c.perturb(ctx, chaosScan, index, attempt); err != nil {
+		return &chaosIterator{iterator: t, err: err}
+	}
+	return t
+}
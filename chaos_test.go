@@ -0,0 +1,72 @@
+package generator
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestChaos(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	run := func(maxRetries int) (int, int, error) {
+		var mu sync.Mutex
+		injected := 0
+		count := 0
+		for unit := range New(ctx, &Options{
+			Appender:               appender,
+			Chaos:                  &Chaos{ErrorRate: 0.5, LatencyMean: time.Millisecond, Seed: 1},
+			ChunkSize:              chunkSize,
+			IgnoreErrFieldMismatch: true,
+			MaxRetries:             maxRetries,
+			OnError: func(err error) {
+				if errors.Cause(err) == ErrChaos {
+					mu.Lock()
+					injected++
+					mu.Unlock()
+				}
+			},
+			ParentKey:    parentKey,
+			Query:        datastore.NewQuery("testHoge").Ancestor(parentKey),
+			RetryBackoff: 1,
+		}) {
+			if unit.Err != nil {
+				return count, injected, unit.Err
+			}
+			count += len(unit.Entities)
+		}
+		return count, injected, nil
+	}
+
+	count, injected, err := run(20)
+	if err != nil {
+		t.Fatalf("error in unit: %+v", err)
+	}
+	if count != allHoges {
+		t.Fatalf("number differs => expected: %d, result: %d", allHoges, count)
+	}
+	if injected == 0 {
+		t.Fatalf("no error is injected")
+	}
+
+	// the same seed injects the same errors.
+	if _, again, err := run(20); err != nil || again != injected {
+		t.Fatalf("injected errors differ => expected: %d, result: %d, %v", injected, again, err)
+	}
+
+	if _, _, err := run(0); errors.Cause(err) != ErrChaos {
+		t.Fatalf("ErrChaos is not yielded without retries: %v", err)
+	}
+}
@@ -0,0 +1,55 @@
+package generator
+
+// checkpoint yields Units from in and calls Checkpoint with the end cursor of
+// the chunks yielded so far, every CheckpointEvery chunks.  Chunks arrive out
+// of order, so the cursor is advanced only when all the preceding chunks have
+// been yielded.  Failed chunks block it unless ContinueOnError.  Errors of the
+// query always block it because they have no end cursor.  It runs in one
+// goroutine, so Checkpoint is not called concurrently.
+func checkpoint(in <-chan Unit, o *Options) <-chan Unit {
+	out := make(chan Unit)
+
+	every := o.CheckpointEvery
+	if every <= 0 {
+		every = 1
+	}
+
+	go func() {
+		defer close(out)
+
+		yielded := make(map[int]string)
+		next := 0
+		last := 0
+		cursor := ""
+
+		for u := range in {
+			out <- u
+
+			// an empty cursor would restart the query from the beginning.
+			if u.meta == nil || u.meta.end == "" || u.Err != nil && !o.ContinueOnError {
+				continue
+			}
+			yielded[u.meta.index] = u.meta.end
+			for {
+				end, ok := yielded[next]
+				if !ok {
+					break
+				}
+				delete(yielded, next)
+				next++
+				cursor = end
+			}
+
+			if next-last >= every {
+				last = next
+				o.Checkpoint(cursor)
+			}
+		}
+
+		if next > last {
+			o.Checkpoint(cursor)
+		}
+	}()
+
+	return out
+}
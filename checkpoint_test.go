@@ -0,0 +1,84 @@
+package generator
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestCheckpoint(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	q := datastore.NewQuery("testHoge").Ancestor(parentKey)
+	var cursors []string
+	chunks := 0
+	for unit := range New(ctx, &Options{
+		Appender:               appender,
+		Checkpoint:             func(cursor string) { cursors = append(cursors, cursor) },
+		CheckpointEvery:        2,
+		ChunkSize:              chunkSize,
+		IgnoreErrFieldMismatch: true,
+		ParentKey:              parentKey,
+		PreserveOrder:          true,
+		Query:                  q,
+	}) {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		chunks++
+	}
+
+	if expected := (chunks + 1) / 2; len(cursors) != expected {
+		t.Fatalf("number of checkpoints differs => expected: %d, result: %d", expected, len(cursors))
+	}
+
+	// the query resumes from the first checkpoint after 2 chunks.
+	if err := testFetch(ctx, allHoges-chunkSize*2, &Options{
+		IgnoreErrFieldMismatch: true,
+		ParentKey:              parentKey,
+		Query:                  q,
+		StartCursor:            cursors[0],
+	}); err != nil {
+		t.Fatalf("error in testFetch: %+v", err)
+	}
+
+	// nothing is left after the last checkpoint.
+	if err := testFetch(ctx, 0, &Options{
+		IgnoreErrFieldMismatch: true,
+		ParentKey:              parentKey,
+		Query:                  q,
+		StartCursor:            cursors[len(cursors)-1],
+	}); err != nil {
+		t.Fatalf("error in testFetch: %+v", err)
+	}
+}
+
+func TestCheckpointWithQueryError(t *testing.T) {
+	in := make(chan Unit)
+	go func() {
+		defer close(in)
+		in <- Unit{meta: &chunkMeta{index: 0, end: "end0"}}
+		in <- Unit{Err: errors.New("error in GetMulti"), Kind: UnitError, meta: &chunkMeta{index: 1, end: "end1"}}
+		in <- Unit{Err: errors.New("error in Next"), Kind: UnitError, meta: &chunkMeta{index: 2}}
+	}()
+
+	var cursors []string
+	Drain(checkpoint(in, &Options{
+		Checkpoint:      func(cursor string) { cursors = append(cursors, cursor) },
+		ContinueOnError: true,
+	}))
+
+	if len(cursors) != 2 || cursors[0] != "end0" || cursors[1] != "end1" {
+		t.Fatalf("cursors differ: %q", cursors)
+	}
+}
@@ -0,0 +1,37 @@
+package generator
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// CollectMap runs the generator to the end and returns all entities in a map
+// keyed by their encoded keys.  The keys are Keys in Unit, so they are in
+// Namespace in Options.  The last one wins if the same key appears twice.  It
+// returns the first error in the stream.
+func CollectMap(ctx context.Context, o *Options) (map[string]interface{}, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ch := New(ctx, o)
+
+	m := make(map[string]interface{})
+	for unit := range ch {
+		if unit.Err != nil {
+			cancel()
+			Drain(ch)
+			return nil, errors.WithStack(unit.Err)
+		}
+
+		if len(unit.Keys) != len(unit.Entities) {
+			cancel()
+			Drain(ch)
+			return nil, errors.Errorf("keys are not aligned with entities => len(entities): %d, len(keys): %d", len(unit.Entities), len(unit.Keys))
+		}
+		for i, e := range unit.Entities {
+			m[unit.Keys[i].Encode()] = e
+		}
+	}
+
+	return m, nil
+}
@@ -0,0 +1,72 @@
+package generator
+
+import (
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+)
+
+func TestCollectMap(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	m, err := CollectMap(ctx, &Options{
+		Appender:               appender,
+		ChunkSize:              chunkSize,
+		IgnoreErrFieldMismatch: true,
+		ParentKey:              parentKey,
+		Query:                  datastore.NewQuery("testHoge").Ancestor(parentKey),
+	})
+	if err != nil {
+		t.Fatalf("error in CollectMap: %+v", err)
+	}
+
+	if len(m) != allHoges {
+		t.Fatalf("number differs => expected: %d, result: %d", allHoges, len(m))
+	}
+
+	for encoded, e := range m {
+		k, err := datastore.DecodeKey(encoded)
+		if err != nil {
+			t.Fatalf("error in DecodeKey: %+v", err)
+		}
+		h, ok := e.(*testHoge)
+		if !ok {
+			t.Fatalf("e is not *testHoge: %+v", e)
+		}
+		if k.IntID() != h.ID || !k.Parent().Equal(parentKey) {
+			t.Fatalf("key does not match the entity => key: %v, entity: %+v", k, h)
+		}
+	}
+}
+
+func TestCollectMapWithError(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	m, err := CollectMap(ctx, &Options{
+		Appender:  appender,
+		ChunkSize: chunkSize,
+		ParentKey: parentKey,
+		Query:     datastore.NewQuery("testHoge").Ancestor(parentKey),
+	})
+	if err == nil || m != nil {
+		t.Fatalf("no error in CollectMap")
+	}
+}
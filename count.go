@@ -0,0 +1,35 @@
+package generator
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// Count returns the number of keys of the query.  It scans the keys with
+// cursors as KeysOnly does, so Appender and GetMulti are not used.  The other
+// options of the query such as Namespace, ExcludeFilter and Limit are
+// honored.  It still costs a small operation for each key.
+func Count(ctx context.Context, o *Options) (int, error) {
+	var oc Options
+	if o != nil {
+		oc = *o
+	}
+	oc.KeysOnly = true
+
+	ctx, cancel := context.WithCancel(ctx)
+	ch := New(ctx, &oc)
+	defer func() {
+		cancel()
+		Drain(ch)
+	}()
+
+	count := 0
+	for unit := range ch {
+		if unit.Err != nil {
+			return 0, errors.WithStack(unit.Err)
+		}
+		count += len(unit.Entities)
+	}
+
+	return count, nil
+}
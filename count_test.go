@@ -0,0 +1,38 @@
+package generator
+
+import (
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+)
+
+func TestCount(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	q := datastore.NewQuery("testHoge").Ancestor(parentKey)
+	for _, c := range []struct {
+		o        *Options
+		expected int
+	}{
+		{&Options{ChunkSize: chunkSize, Query: q}, allHoges + 1},
+		{&Options{ChunkSize: chunkSize, Query: q.Filter("Name =", "Fuga Hogeo")}, allFugas},
+		{&Options{ChunkSize: chunkSize, Limit: 7, Query: q}, 7},
+	} {
+		count, err := Count(ctx, c.o)
+		if err != nil {
+			t.Fatalf("error in Count: %+v", err)
+		}
+		if count != c.expected {
+			t.Fatalf("count differs => expected: %d, result: %d", c.expected, count)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package generator
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// now returns the current time.  This can be replaced in tests.
+var now = time.Now
+
+// stopTime returns the time to stop the query before the deadline of ctx.  It
+// is zero if StopBeforeDeadline is not set or ctx has no deadline.
+func stopTime(ctx context.Context, o *Options) time.Time {
+	if o.StopBeforeDeadline <= 0 {
+		return time.Time{}
+	}
+	d, ok := ctx.Deadline()
+	if !ok {
+		return time.Time{}
+	}
+	return d.Add(-o.StopBeforeDeadline)
+}
+
+// yieldStopped yields Units from in, and a Unit of UnitStopped after in is
+// closed if the query has stopped before the deadline.  It has the end cursor
+// of the last chunk to resume from.
+func yieldStopped(ctx context.Context, in <-chan Unit) <-chan Unit {
+	out := make(chan Unit)
+
+	go func() {
+		defer close(out)
+
+		stopped := false
+		cursor := ""
+		for u := range in {
+			if u.Err == nil && u.meta != nil && u.meta.stopped {
+				stopped = true
+				cursor = u.meta.end
+			}
+			out <- u
+		}
+
+		if !stopped || ctx.Err() != nil {
+			return
+		}
+		out <- Unit{Kind: UnitStopped, Cursor: cursor}
+	}()
+
+	return out
+}
@@ -0,0 +1,109 @@
+package generator
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mjibson/goon"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+type clockIterator struct {
+	iterator
+	tick func()
+}
+
+func (t *clockIterator) Next(dst interface{}) (*datastore.Key, error) {
+	t.tick()
+	return t.iterator.Next(dst)
+}
+
+func TestStopBeforeDeadline(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	deadline := time.Now().Add(time.Hour)
+	dctx, dcancel := context.WithDeadline(ctx, deadline)
+	defer dcancel()
+
+	// the clock reaches the deadline after 25 keys.
+	origNow, origQuery := now, runQuery
+	defer func() { now, runQuery = origNow, origQuery }()
+	var mu sync.Mutex
+	calls := 0
+	now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		if calls > 25 {
+			return deadline
+		}
+		return time.Now()
+	}
+	runQuery = func(g *goon.Goon, q *datastore.Query) iterator {
+		return &clockIterator{iterator: origQuery(g, q), tick: func() {
+			mu.Lock()
+			calls++
+			mu.Unlock()
+		}}
+	}
+
+	o := &Options{
+		Appender:               appender,
+		ChunkSize:              chunkSize,
+		IgnoreErrFieldMismatch: true,
+		ParentKey:              parentKey,
+		Query:                  datastore.NewQuery("testHoge").Ancestor(parentKey),
+		StopBeforeDeadline:     time.Minute,
+	}
+
+	ids := map[int64]bool{}
+	var last Unit
+	for unit := range New(dctx, o) {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		for _, e := range unit.Entities {
+			ids[e.(*testHoge).ID] = true
+		}
+		last = unit
+	}
+
+	if len(ids) == 0 || len(ids) >= allHoges {
+		t.Fatalf("the query does not stop early: %d", len(ids))
+	}
+	if last.Kind != UnitStopped || last.Cursor == "" {
+		t.Fatalf("last Unit is not resumable: %+v", last)
+	}
+
+	// the rest is fetched from the cursor.
+	now = origNow
+	o.StartCursor = last.Cursor
+	for unit := range New(ctx, o) {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		if unit.Kind == UnitStopped {
+			t.Fatalf("the query stops without a deadline")
+		}
+		for _, e := range unit.Entities {
+			id := e.(*testHoge).ID
+			if ids[id] {
+				t.Fatalf("entity %d is yielded again", id)
+			}
+			ids[id] = true
+		}
+	}
+	if len(ids) != allHoges {
+		t.Fatalf("number differs => expected: %d, result: %d", allHoges, len(ids))
+	}
+}
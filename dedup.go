@@ -0,0 +1,90 @@
+package generator
+
+import "sync"
+
+// SeenSet remembers the encoded keys yielded for Dedup.  BloomFilter can be
+// used for bounded memory, though it drops some keys that have not been
+// yielded at its false positive rate.  It must be safe for concurrent use,
+// because Merge shares one SeenSet among its pipelines.
+type SeenSet interface {
+	KeyFilter
+	Add(key string)
+}
+
+// claimer is implemented by the SeenSets that can check and add a key at once.
+// Otherwise two pipelines of Merge could both yield a key between Contains
+// and Add.
+type claimer interface {
+	// claim adds the key and returns true if it has not been added.
+	claim(key string) bool
+}
+
+// claim adds the key to s and returns true if it has not been added.
+func claim(s SeenSet, key string) bool {
+	if c, ok := s.(claimer); ok {
+		return c.claim(key)
+	}
+	if s.Contains(key) {
+		return false
+	}
+	s.Add(key)
+	return true
+}
+
+// keySet is the default SeenSet.  It grows with every key of the query.
+type keySet struct {
+	mu   sync.Mutex
+	keys map[string]struct{}
+}
+
+func newKeySet() *keySet {
+	return &keySet{keys: make(map[string]struct{})}
+}
+
+func (s *keySet) Add(key string) {
+	s.mu.Lock()
+	s.keys[key] = struct{}{}
+	s.mu.Unlock()
+}
+
+func (s *keySet) Contains(key string) bool {
+	s.mu.Lock()
+	_, ok := s.keys[key]
+	s.mu.Unlock()
+	return ok
+}
+
+func (s *keySet) claim(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.keys[key]; ok {
+		return false
+	}
+	s.keys[key] = struct{}{}
+	return true
+}
+
+// lockedSet serializes a SeenSet given by the user, so that Merge can share it
+// among its pipelines.
+type lockedSet struct {
+	mu  sync.Mutex
+	set SeenSet
+}
+
+func (s *lockedSet) Add(key string) {
+	s.mu.Lock()
+	s.set.Add(key)
+	s.mu.Unlock()
+}
+
+func (s *lockedSet) Contains(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.set.Contains(key)
+}
+
+func (s *lockedSet) claim(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return claim(s.set, key)
+}
@@ -0,0 +1,127 @@
+package generator
+
+import (
+	"testing"
+
+	"github.com/mjibson/goon"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// duplicatingIterator yields every key twice.
+type duplicatingIterator struct {
+	iterator
+	last *datastore.Key
+}
+
+func (t *duplicatingIterator) Next(dst interface{}) (*datastore.Key, error) {
+	if t.last != nil {
+		k := t.last
+		t.last = nil
+		return k, nil
+	}
+	k, err := t.iterator.Next(dst)
+	if err == nil {
+		t.last = k
+	}
+	return k, err
+}
+
+func TestDedup(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	orig := runQuery
+	defer func() { runQuery = orig }()
+	runQuery = func(g *goon.Goon, q *datastore.Query) iterator {
+		return &duplicatingIterator{iterator: orig(g, q)}
+	}
+
+	for _, seen := range []SeenSet{nil, NewBloomFilter(allHoges*10, 0.0001)} {
+		keys := map[string]int{}
+		for unit := range New(ctx, &Options{
+			ChunkSize: chunkSize,
+			Dedup:     true,
+			KeysOnly:  true,
+			Query:     datastore.NewQuery("testHoge").Ancestor(parentKey),
+			SeenSet:   seen,
+		}) {
+			if unit.Err != nil {
+				t.Fatalf("error in unit: %+v", unit.Err)
+			}
+			for _, e := range unit.Entities {
+				keys[e.(*datastore.Key).Encode()]++
+			}
+		}
+
+		if len(keys) != allHoges+1 {
+			t.Fatalf("number of keys differs => expected: %d, result: %d", allHoges+1, len(keys))
+		}
+		for k, n := range keys {
+			if n != 1 {
+				t.Fatalf("key is yielded %d times: %s", n, k)
+			}
+		}
+	}
+}
+
+func TestMergeWithDedup(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	q := datastore.NewQuery("testHoge").Ancestor(parentKey)
+	for _, seen := range []SeenSet{nil, NewBloomFilter(allHoges*10, 0.0001)} {
+		keys := map[string]int{}
+		for unit := range Merge(ctx, []*Options{
+			{ChunkSize: chunkSize, Dedup: true, KeysOnly: true, Query: q, SeenSet: seen},
+			{ChunkSize: chunkSize / 2, Dedup: true, KeysOnly: true, Query: q, SeenSet: seen},
+			{ChunkSize: chunkSize, Dedup: true, KeysOnly: true, Query: q.Limit(allHoges / 2), SeenSet: seen},
+		}) {
+			if unit.Err != nil {
+				t.Fatalf("error in unit: %+v", unit.Err)
+			}
+			for _, e := range unit.Entities {
+				keys[e.(*datastore.Key).Encode()]++
+			}
+		}
+
+		if len(keys) != allHoges+1 {
+			t.Fatalf("number of keys differs => expected: %d, result: %d", allHoges+1, len(keys))
+		}
+		for k, n := range keys {
+			if n != 1 {
+				t.Fatalf("key is yielded %d times: %s", n, k)
+			}
+		}
+	}
+}
+
+func TestMergeWithDifferentSeenSets(t *testing.T) {
+	q := datastore.NewQuery("testHoge")
+	var units []Unit
+	for u := range Merge(context.Background(), []*Options{
+		{Dedup: true, KeysOnly: true, Query: q, SeenSet: NewBloomFilter(allHoges, 0.01)},
+		{Dedup: true, KeysOnly: true, Query: q, SeenSet: NewBloomFilter(allHoges, 0.01)},
+	}) {
+		units = append(units, u)
+	}
+	if len(units) != 1 || units[0].Err == nil {
+		t.Fatalf("error is not yielded: %+v", units)
+	}
+}
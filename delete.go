@@ -0,0 +1,54 @@
+package generator
+
+import (
+	"github.com/mjibson/goon"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+const deleteBatchSize = 500
+
+// DeleteAll deletes every key of the query in batches of 500 keys, and returns
+// the number of deleted keys.  Appender is not needed.  It stops when ctx is
+// done or DeleteMulti fails, and the number tells the keys deleted before
+// that.  The error of DeleteMulti may be a MultiError for the failed batch.
+// The keys are deleted with Goon in Options if it is set, so that its cache
+// drops them.
+func DeleteAll(ctx context.Context, o *Options) (int, error) {
+	var oc Options
+	if o != nil {
+		oc = *o
+	}
+	oc.KeysOnly = true
+
+	nctx, err := withNamespace(ctx, &oc)
+	if err != nil {
+		return 0, err
+	}
+
+	g := fromContext(nctx, oc.Goon)
+	deleted := 0
+	err = Batch(ctx, &oc, deleteBatchSize, func(ctx context.Context, batch []interface{}) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		keys := make([]*datastore.Key, len(batch))
+		for i, e := range batch {
+			keys[i] = e.(*datastore.Key)
+		}
+		if err := deleteKeys(g, keys); err != nil {
+			return errors.Wrap(err, "error in DeleteMulti")
+		}
+		deleted += len(keys)
+		return nil
+	})
+
+	return deleted, err
+}
+
+// deleteKeys deletes keys.  This can be replaced in tests.
+var deleteKeys = func(g *goon.Goon, keys []*datastore.Key) error {
+	return g.DeleteMulti(keys)
+}
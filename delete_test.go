@@ -0,0 +1,79 @@
+package generator
+
+import (
+	"testing"
+
+	"github.com/mjibson/goon"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestDeleteAll(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	deleted, err := DeleteAll(ctx, &Options{
+		ChunkSize: chunkSize,
+		Query:     datastore.NewQuery("testHoge").Ancestor(parentKey).Filter("Name =", "Fuga Hogeo"),
+	})
+	if err != nil {
+		t.Fatalf("error in DeleteAll: %+v", err)
+	}
+	if deleted != allFugas {
+		t.Fatalf("number of deleted differs => expected: %d, result: %d", allFugas, deleted)
+	}
+
+	// Hoge Fugao and testOldHoge remain.
+	count, err := datastore.NewQuery("testHoge").Ancestor(parentKey).Count(ctx)
+	if err != nil {
+		t.Fatalf("error in Count: %+v", err)
+	}
+	if expected := allHoges - allFugas + 1; count != expected {
+		t.Fatalf("number of remaining differs => expected: %d, result: %d", expected, count)
+	}
+}
+
+func TestDeleteAllWithGoon(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	orig := deleteKeys
+	defer func() { deleteKeys = orig }()
+	used := map[*goon.Goon]bool{}
+	deleteKeys = func(g *goon.Goon, keys []*datastore.Key) error {
+		used[g] = true
+		return orig(g, keys)
+	}
+
+	g := goon.FromContext(ctx)
+	deleted, err := DeleteAll(ctx, &Options{
+		ChunkSize: chunkSize,
+		Goon:      g,
+		Query:     datastore.NewQuery("testHoge").Ancestor(parentKey),
+	})
+	if err != nil {
+		t.Fatalf("error in DeleteAll: %+v", err)
+	}
+	if deleted != allHoges+1 {
+		t.Fatalf("number of deleted differs => expected: %d, result: %d", allHoges+1, deleted)
+	}
+
+	if len(used) != 1 || !used[g] {
+		t.Fatalf("Goon in Options is not used: %v", used)
+	}
+}
@@ -0,0 +1,17 @@
+package generator
+
+// Drain receives and discards the remaining Units from ch until it is closed,
+// so that the goroutines of the generator can finish.  A consumer that stops
+// early should cancel the context given to New first, and then drain the
+// channel.  Without cancel, Drain waits for the whole query.
+//
+//	ctx, cancel := context.WithCancel(ctx)
+//	ch := generator.New(ctx, o)
+//	defer func() {
+//	  cancel()
+//	  generator.Drain(ch)
+//	}()
+func Drain(ch <-chan Unit) {
+	for range ch {
+	}
+}
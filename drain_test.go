@@ -0,0 +1,17 @@
+package generator
+
+import "testing"
+
+func TestDrain(t *testing.T) {
+	ch := make(chan Unit, 3)
+	for i := 0; i < 3; i++ {
+		ch <- Unit{}
+	}
+	close(ch)
+
+	Drain(ch)
+
+	if _, ok := <-ch; ok {
+		t.Fatalf("ch has not been drained")
+	}
+}
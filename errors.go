@@ -0,0 +1,263 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+// KeyError is an error for the entity that has the key.  Key may be nil when
+// no keys are given to ClassifyError.
+type KeyError struct {
+	// Index is the index in the chunk.  It is -1 when err is not a
+	// MultiError.
+	Index int
+	Key   *datastore.Key
+	Err   error
+}
+
+func (e KeyError) Error() string {
+	if e.Key == nil {
+		return fmt.Sprintf("entities[%d]: %v", e.Index, e.Err)
+	}
+	return fmt.Sprintf("entities[%d] (%v): %v", e.Index, e.Key, e.Err)
+}
+
+// ClassifyError partitions the error in Unit by its category.  keys should be
+// the keys of the entities in the chunk, in the same order.  If err is not a
+// MultiError, it is returned in other as it is.
+func ClassifyError(err error, keys []*datastore.Key) (fieldMismatches []KeyError, notFound []KeyError, other []KeyError) {
+	if err == nil {
+		return nil, nil, nil
+	}
+
+	mErr, ok := errors.Cause(err).(appengine.MultiError)
+	if !ok {
+		return nil, nil, []KeyError{{Index: -1, Err: err}}
+	}
+
+	for i, e := range mErr {
+		if e == nil {
+			continue
+		}
+		ke := KeyError{Index: i, Err: e}
+		if i < len(keys) {
+			ke.Key = keys[i]
+		}
+		if _, ok := e.(*datastore.ErrFieldMismatch); ok {
+			fieldMismatches = append(fieldMismatches, ke)
+		} else if e == datastore.ErrNoSuchEntity {
+			notFound = append(notFound, ke)
+		} else {
+			other = append(other, ke)
+		}
+	}
+
+	return fieldMismatches, notFound, other
+}
+
+// ChunkError is an error for the chunk that GetMulti has failed.  Its message
+// summarizes the MultiError such as "chunk 3: 2 ErrFieldMismatch, 1
+// ErrNoSuchEntity".  errors.Cause() returns the MultiError, and errors.As()
+// of Go 1.20 or later can reach each error in it.  Unit.Err has it as it is,
+// not wrapped.
+type ChunkError struct {
+	// Index is the index of the chunk in the stream.
+	Index int
+	// Err is the MultiError returned from GetMulti.
+	Err appengine.MultiError
+}
+
+// newChunkError wraps err with ChunkError if it is a MultiError.
+func newChunkError(i int, err error) error {
+	if mErr, ok := err.(appengine.MultiError); ok {
+		return &ChunkError{Index: i, Err: mErr}
+	}
+	return err
+}
+
+func (e *ChunkError) Error() string {
+	fieldMismatches, notFound, other := ClassifyError(e.Err, nil)
+
+	var counts []string
+	if len(fieldMismatches) > 0 {
+		counts = append(counts, fmt.Sprintf("%d ErrFieldMismatch", len(fieldMismatches)))
+	}
+	if len(notFound) > 0 {
+		counts = append(counts, fmt.Sprintf("%d ErrNoSuchEntity", len(notFound)))
+	}
+	if len(other) > 0 {
+		counts = append(counts, fmt.Sprintf("%d other", len(other)))
+	}
+
+	return fmt.Sprintf("chunk %d: %s", e.Index, strings.Join(counts, ", "))
+}
+
+// Cause returns the underlying MultiError.
+func (e *ChunkError) Cause() error { return e.Err }
+
+// Unwrap returns the errors in the MultiError.
+func (e *ChunkError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Err))
+	for _, err := range e.Err {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// ErrNeedsIndex is the error when the query needs a composite index that does
+// not exist.
+type ErrNeedsIndex struct {
+	// Query describes the query.
+	Query string
+	// Suggestion is the snippet to add to index.yaml.  It is empty if the
+	// datastore does not tell it.
+	Suggestion string
+	// Err is the original error.
+	Err error
+}
+
+func (e *ErrNeedsIndex) Error() string {
+	if e.Suggestion == "" {
+		return fmt.Sprintf("query needs an index: %s", e.Query)
+	}
+	return fmt.Sprintf("query needs an index: %s\nadd this to index.yaml:\n%s", e.Query, e.Suggestion)
+}
+
+// Cause returns the original error.
+func (e *ErrNeedsIndex) Cause() error { return e.Err }
+
+// needsIndex wraps err with ErrNeedsIndex if it is a "no matching index"
+// error.
+func needsIndex(err error, q *datastore.Query) error {
+	msg := err.Error()
+	if !strings.Contains(msg, "no matching index") {
+		return err
+	}
+
+	suggestion := ""
+	if i := strings.Index(msg, "- kind:"); i >= 0 {
+		suggestion = strings.TrimSpace(msg[i:])
+	}
+
+	return &ErrNeedsIndex{
+		Query:      fmt.Sprintf("%+v", q),
+		Suggestion: suggestion,
+		Err:        err,
+	}
+}
+
+// errorList is the list of errors yielded in a run.
+type errorList struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+func (l *errorList) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.errs = nil
+}
+
+func (l *errorList) get() []error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	errs := make([]error, len(l.errs))
+	copy(errs, l.errs)
+
+	return errs
+}
+
+// record records the error of every Unit from in before yielding it.
+func (l *errorList) record(in <-chan Unit) <-chan Unit {
+	out := make(chan Unit)
+
+	go func() {
+		defer close(out)
+
+		for u := range in {
+			if u.Err != nil {
+				l.mu.Lock()
+				l.errs = append(l.errs, u.Err)
+				l.mu.Unlock()
+			}
+			out <- u
+		}
+	}()
+
+	return out
+}
+
+// reportError calls OnError with err if it is set.
+func reportError(o *Options, err error) {
+	if o.OnError != nil {
+		o.OnError(err)
+	}
+}
+
+// SchemaDriftError is the error of Generator when ErrFieldMismatch has been
+// ignored in the run with FailIfAnyMismatch in Options.  The entities that
+// have it are not yielded, so the schema may have drifted from the struct.
+type SchemaDriftError struct {
+	// Count is the number of the ignored ErrFieldMismatch.
+	Count int
+	// Fields has the names of the fields in ErrFieldMismatch without
+	// duplicates.
+	Fields []string
+}
+
+func (e *SchemaDriftError) Error() string {
+	return fmt.Sprintf("schema drift: %d ErrFieldMismatch ignored for fields: %s", e.Count, strings.Join(e.Fields, ", "))
+}
+
+// driftList is the list of ErrFieldMismatch ignored in a run.
+type driftList struct {
+	mu     sync.Mutex
+	count  int
+	fields []string
+}
+
+func (l *driftList) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.count = 0
+	l.fields = nil
+}
+
+// add records err if it is ErrFieldMismatch.
+func (l *driftList) add(err error) {
+	fErr, ok := errors.Cause(err).(*datastore.ErrFieldMismatch)
+	if !ok {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.count++
+	for _, f := range l.fields {
+		if f == fErr.FieldName {
+			return
+		}
+	}
+	l.fields = append(l.fields, fErr.FieldName)
+}
+
+// err returns SchemaDriftError if any ErrFieldMismatch has been recorded.
+func (l *driftList) err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.count == 0 {
+		return nil
+	}
+
+	fields := make([]string, len(l.fields))
+	copy(fields, l.fields)
+	return &SchemaDriftError{Count: l.count, Fields: fields}
+}
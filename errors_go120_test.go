@@ -0,0 +1,29 @@
+//go:build go1.20
+// +build go1.20
+
+package generator
+
+import (
+	"errors"
+	"testing"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestChunkErrorUnwrap(t *testing.T) {
+	err := newChunkError(3, appengine.MultiError{
+		&datastore.ErrFieldMismatch{FieldName: "OldName"},
+		nil,
+		datastore.ErrNoSuchEntity,
+	})
+
+	var fErr *datastore.ErrFieldMismatch
+	if !errors.As(err, &fErr) || fErr.FieldName != "OldName" {
+		t.Fatalf("errors.As does not reach ErrFieldMismatch: %+v", err)
+	}
+
+	if !errors.Is(err, datastore.ErrNoSuchEntity) {
+		t.Fatalf("errors.Is does not reach ErrNoSuchEntity: %+v", err)
+	}
+}
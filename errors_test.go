@@ -0,0 +1,118 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestClassifyError(t *testing.T) {
+	ctx := context.Background()
+	keys := make([]*datastore.Key, 5)
+	for i := range keys {
+		keys[i] = datastore.NewKey(ctx, "testHoge", "", int64(i+1), nil)
+	}
+
+	hogeErr := errors.New("hoge error")
+	mErr := appengine.MultiError{
+		nil,
+		&datastore.ErrFieldMismatch{FieldName: "OldName"},
+		datastore.ErrNoSuchEntity,
+		hogeErr,
+		&datastore.ErrFieldMismatch{FieldName: "OldName"},
+	}
+
+	fieldMismatches, notFound, other := ClassifyError(errors.WithStack(mErr), keys)
+
+	if len(fieldMismatches) != 2 || fieldMismatches[0].Index != 1 || fieldMismatches[1].Index != 4 {
+		t.Fatalf("fieldMismatches differs: %+v", fieldMismatches)
+	}
+	if !fieldMismatches[0].Key.Equal(keys[1]) || !fieldMismatches[1].Key.Equal(keys[4]) {
+		t.Fatalf("keys for fieldMismatches differ: %+v", fieldMismatches)
+	}
+	if len(notFound) != 1 || notFound[0].Index != 2 || !notFound[0].Key.Equal(keys[2]) {
+		t.Fatalf("notFound differs: %+v", notFound)
+	}
+	if len(other) != 1 || other[0].Index != 3 || other[0].Err != hogeErr {
+		t.Fatalf("other differs: %+v", other)
+	}
+}
+
+func TestClassifyNonMultiError(t *testing.T) {
+	hogeErr := errors.New("hoge error")
+
+	fieldMismatches, notFound, other := ClassifyError(hogeErr, nil)
+	if fieldMismatches != nil || notFound != nil {
+		t.Fatalf("fieldMismatches or notFound is not nil")
+	}
+	if len(other) != 1 || other[0].Index != -1 || other[0].Key != nil || other[0].Err != hogeErr {
+		t.Fatalf("other differs: %+v", other)
+	}
+}
+
+func TestChunkError(t *testing.T) {
+	mErr := appengine.MultiError{
+		&datastore.ErrFieldMismatch{FieldName: "OldName"},
+		nil,
+		datastore.ErrNoSuchEntity,
+		&datastore.ErrFieldMismatch{FieldName: "OldName"},
+	}
+	err := newChunkError(3, mErr)
+
+	expected := "chunk 3: 2 ErrFieldMismatch, 1 ErrNoSuchEntity"
+	if err.Error() != expected {
+		t.Fatalf("message differs => expected: %s, result: %s", expected, err.Error())
+	}
+
+	if _, ok := errors.Cause(err).(appengine.MultiError); !ok {
+		t.Fatalf("cause is not MultiError: %+v", errors.Cause(err))
+	}
+
+}
+
+func TestNewChunkErrorWithNonMultiError(t *testing.T) {
+	hogeErr := errors.New("hoge error")
+	if err := newChunkError(3, hogeErr); err != hogeErr {
+		t.Fatalf("err is wrapped: %+v", err)
+	}
+}
+
+func TestNeedsIndex(t *testing.T) {
+	q := datastore.NewQuery("testHoge").Filter("Name =", "Fuga Hogeo").Order("-ID")
+	detail := `API error 4 (datastore_v3: NEED_INDEX): no matching index found. recommended index is:
+- kind: testHoge
+  properties:
+  - name: Name
+  - name: ID
+    direction: desc
+`
+	err := needsIndex(errors.New(detail), q)
+
+	nErr, ok := err.(*ErrNeedsIndex)
+	if !ok {
+		t.Fatalf("err is not ErrNeedsIndex: %+v", err)
+	}
+	if nErr.Query == "" {
+		t.Fatalf("Query is empty")
+	}
+	if !strings.HasPrefix(nErr.Suggestion, "- kind: testHoge") || !strings.Contains(nErr.Suggestion, "direction: desc") {
+		t.Fatalf("Suggestion differs: %s", nErr.Suggestion)
+	}
+	if !strings.Contains(err.Error(), nErr.Suggestion) {
+		t.Fatalf("message does not have the suggestion: %s", err.Error())
+	}
+	if errors.Cause(err).Error() != detail {
+		t.Fatalf("cause differs: %+v", errors.Cause(err))
+	}
+}
+
+func TestNeedsIndexWithOtherError(t *testing.T) {
+	hogeErr := errors.New("hoge error")
+	if err := needsIndex(hogeErr, datastore.NewQuery("testHoge")); err != hogeErr {
+		t.Fatalf("err is wrapped: %+v", err)
+	}
+}
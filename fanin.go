@@ -0,0 +1,200 @@
+package generator
+
+import (
+	"math/rand"
+	"reflect"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// Merge runs the pipeline for each Options and merges their Units into one
+// channel.  The order of Units among the pipelines is not defined.  The
+// returned channel is closed after all pipelines are finished.  When ctx is
+// done, the remaining Units are dropped and all pipelines are stopped.
+// Heartbeat, Summary, Sequence, OnProgress and StopBeforeDeadline cannot be
+// used because they are for a single stream.  The pipelines with Dedup share
+// one SeenSet, so a key is yielded once among them.
+func Merge(ctx context.Context, os []*Options) <-chan Unit {
+	for _, o := range os {
+		if err := mergeConflict(o); err != nil {
+			return errorChannel(err)
+		}
+	}
+	os, err := shareSeenSet(os)
+	if err != nil {
+		return errorChannel(err)
+	}
+
+	out := make(chan Unit)
+
+	var wg sync.WaitGroup
+	for _, o := range os {
+		wg.Add(1)
+		go func(ch <-chan Unit) {
+			defer wg.Done()
+			// drain ch after ctx is done so that the pipeline can exit.
+			for u := range ch {
+				select {
+				case out <- u:
+				case <-ctx.Done():
+				}
+			}
+		}(New(ctx, o))
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return out
+}
+
+// mergeConflict returns an error if o has options that are for a single
+// stream.  Each pipeline would yield its own UnitDone, Seq from 0, progress
+// and UnitStopped.
+func mergeConflict(o *Options) error {
+	if o != nil && (o.Heartbeat > 0 || o.Summary || o.Sequence || o.OnProgress != nil || o.StopBeforeDeadline > 0) {
+		return errors.New("Heartbeat, Summary, Sequence, OnProgress and StopBeforeDeadline cannot be used with Merge")
+	}
+	return nil
+}
+
+// shareSeenSet returns copies of os whose pipelines with Dedup share one
+// SeenSet.  It is the SeenSet given to them, serialized by a lock, or a new
+// keySet.  Different SeenSets cannot be shared.
+func shareSeenSet(os []*Options) ([]*Options, error) {
+	var seen SeenSet
+	for _, o := range os {
+		if o == nil || !o.Dedup || o.SeenSet == nil {
+			continue
+		}
+		if seen != nil && !sameSet(seen, o.SeenSet) {
+			return nil, errors.New("different SeenSets cannot be used with Merge")
+		}
+		seen = o.SeenSet
+	}
+	if seen == nil {
+		seen = newKeySet()
+	} else if _, ok := seen.(claimer); !ok {
+		seen = &lockedSet{set: seen}
+	}
+
+	shared := make([]*Options, len(os))
+	for i, o := range os {
+		shared[i] = o
+		if o != nil && o.Dedup {
+			oc := *o
+			oc.SeenSet = seen
+			shared[i] = &oc
+		}
+	}
+	return shared, nil
+}
+
+// sameSet tells whether a and b are the same SeenSet.  It does not compare
+// values of map types with ==, which panics.
+func sameSet(a, b SeenSet) bool {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if va.Type() != vb.Type() {
+		return false
+	}
+	switch va.Kind() {
+	case reflect.Map, reflect.Ptr, reflect.Slice, reflect.Chan, reflect.Func:
+		return va.Pointer() == vb.Pointer()
+	}
+	return va.Type().Comparable() && a == b
+}
+
+// WeightedFanIn merges Units from sources into one channel.  When some sources
+// have Units ready at the same time, it chooses one of them at random in
+// proportion to its weight.  Weights less than 1 are treated as 1.  The
+// returned channel is closed after all sources are closed.
+func WeightedFanIn(sources map[<-chan Unit]int) <-chan Unit {
+	out := make(chan Unit)
+
+	chs := make([]<-chan Unit, 0, len(sources))
+	weights := make([]int, 0, len(sources))
+	for ch, w := range sources {
+		if w < 1 {
+			w = 1
+		}
+		chs = append(chs, ch)
+		weights = append(weights, w)
+	}
+
+	go func() {
+		defer close(out)
+
+		pending := make([]*Unit, len(chs))
+		closed := make([]bool, len(chs))
+		open := len(chs)
+
+		for {
+			// receive Units that are ready now without blocking.
+			for i, ch := range chs {
+				if closed[i] || pending[i] != nil {
+					continue
+				}
+				select {
+				case u, ok := <-ch:
+					if ok {
+						pending[i] = &u
+					} else {
+						closed[i] = true
+						open--
+					}
+				default:
+				}
+			}
+
+			total := 0
+			for i, u := range pending {
+				if u != nil {
+					total += weights[i]
+				}
+			}
+
+			if total == 0 {
+				if open == 0 {
+					return
+				}
+				// wait until any source has a Unit.
+				cases := make([]reflect.SelectCase, 0, open)
+				indices := make([]int, 0, open)
+				for i, ch := range chs {
+					if !closed[i] {
+						cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ch)})
+						indices = append(indices, i)
+					}
+				}
+				chosen, v, ok := reflect.Select(cases)
+				if ok {
+					u := v.Interface().(Unit)
+					pending[indices[chosen]] = &u
+				} else {
+					closed[indices[chosen]] = true
+					open--
+				}
+				continue
+			}
+
+			n := rand.Intn(total)
+			for i, u := range pending {
+				if u == nil {
+					continue
+				}
+				if n < weights[i] {
+					out <- *u
+					pending[i] = nil
+					break
+				}
+				n -= weights[i]
+			}
+		}
+	}()
+
+	return out
+}
@@ -0,0 +1,126 @@
+package generator
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/appengine/datastore"
+)
+
+func TestWeightedFanIn(t *testing.T) {
+	const size = 100
+
+	high := make(chan Unit, size)
+	low := make(chan Unit, size)
+	for i := 0; i < size; i++ {
+		high <- Unit{Entities: []interface{}{"high"}}
+		low <- Unit{Entities: []interface{}{"low"}}
+	}
+	close(high)
+	close(low)
+
+	out := WeightedFanIn(map[<-chan Unit]int{high: 3, low: 1})
+
+	counts := map[string]int{}
+	total := 0
+	for unit := range out {
+		if total < size {
+			counts[unit.Entities[0].(string)]++
+		}
+		total++
+	}
+
+	if total != size*2 {
+		t.Fatalf("number differs => expected: %d, result: %d", size*2, total)
+	}
+
+	// the expected value is 75 and the standard deviation is about 4.3.
+	if counts["high"] < 60 || counts["high"] > 90 {
+		t.Fatalf("high does not dominate => high: %d, low: %d", counts["high"], counts["low"])
+	}
+}
+
+func TestWeightedFanInWithNoSources(t *testing.T) {
+	if _, ok := <-WeightedFanIn(nil); ok {
+		t.Fatalf("out has not been closed")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	q := datastore.NewQuery("testHoge").Ancestor(parentKey)
+	ch := Merge(ctx, []*Options{
+		{Appender: appender, ChunkSize: chunkSize, IgnoreErrFieldMismatch: true, ParentKey: parentKey, Query: q.Filter("Name =", "Hoge Fugao")},
+		{Appender: appender, ChunkSize: chunkSize, IgnoreErrFieldMismatch: true, ParentKey: parentKey, Query: q.Filter("Name =", "Fuga Hogeo")},
+	})
+
+	count := 0
+	for unit := range ch {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		count += len(unit.Entities)
+	}
+
+	if count != allHoges {
+		t.Fatalf("number differs => expected: %d, result: %d", allHoges, count)
+	}
+}
+
+func TestMergeWithCancelled(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	q := datastore.NewQuery("testHoge").Ancestor(parentKey)
+	ch := Merge(ctx, []*Options{
+		{Appender: appender, ChunkSize: 1, IgnoreErrFieldMismatch: true, ParentKey: parentKey, Query: q},
+		{Appender: appender, ChunkSize: 1, IgnoreErrFieldMismatch: true, ParentKey: parentKey, Query: q},
+	})
+
+	<-ch
+	cancel()
+
+	for range ch {
+	}
+}
+
+func TestMergeWithSingleStreamOptions(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	q := datastore.NewQuery("testHoge")
+	for name, o := range map[string]*Options{
+		"Heartbeat":  {Appender: appender, Heartbeat: time.Second, Query: q},
+		"Summary":    {Appender: appender, Summary: true, Query: q},
+		"Sequence":   {Appender: appender, Sequence: true, Query: q},
+		"OnProgress": {Appender: appender, OnProgress: func(int) {}, Query: q},
+	} {
+		var units []Unit
+		for u := range Merge(ctx, []*Options{{Appender: appender, Query: q}, o}) {
+			units = append(units, u)
+		}
+		if len(units) != 1 || units[0].Err == nil {
+			t.Fatalf("error is not yielded for %s: %+v", name, units)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package generator
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// First runs the generator and returns the first n entities.  It stops the
+// generator after that, and returns after its goroutines finish.  If an error
+// occurs before n entities, it returns the entities so far with the error.
+// Unlike Peek, all stages of the generator are used.
+func First(ctx context.Context, o *Options, n int) ([]interface{}, error) {
+	if n <= 0 {
+		return nil, errors.Errorf("invalid number: %d", n)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	ch := New(ctx, o)
+	defer func() {
+		cancel()
+		Drain(ch)
+	}()
+
+	var entities []interface{}
+	for unit := range ch {
+		if unit.Err != nil {
+			return entities, errors.WithStack(unit.Err)
+		}
+
+		for _, e := range unit.Entities {
+			entities = append(entities, e)
+			if len(entities) == n {
+				return entities, nil
+			}
+		}
+	}
+
+	return entities, nil
+}
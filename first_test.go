@@ -0,0 +1,57 @@
+package generator
+
+import (
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+)
+
+func TestFirst(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	q := datastore.NewQuery("testHoge").Ancestor(parentKey)
+	for _, c := range []struct {
+		n        int
+		expected int
+	}{
+		{15, 15},
+		{allHoges * 2, allHoges},
+	} {
+		entities, err := First(ctx, &Options{
+			Appender:               appender,
+			ChunkSize:              chunkSize,
+			IgnoreErrFieldMismatch: true,
+			ParentKey:              parentKey,
+			Query:                  q,
+		}, c.n)
+		if err != nil {
+			t.Fatalf("error in First: %+v", err)
+		}
+		if len(entities) != c.expected {
+			t.Fatalf("number differs => expected: %d, result: %d", c.expected, len(entities))
+		}
+	}
+
+	// the entities before the error are returned.
+	entities, err := First(ctx, &Options{
+		Appender:  appender,
+		ChunkSize: 1,
+		ParentKey: parentKey,
+		Query:     q,
+	}, allHoges*2)
+	if err == nil {
+		t.Fatalf("error is not returned")
+	}
+	if len(entities) >= allHoges+1 {
+		t.Fatalf("entities after the error are returned: %d", len(entities))
+	}
+}
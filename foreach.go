@@ -0,0 +1,32 @@
+package generator
+
+import (
+	"github.com/pkg/errors"
+)
+
+// ForEach calls fn for every entity from ch.  It stops when fn returns an
+// error or a Unit has an error, and returns that error after draining ch so
+// that the generator can finish.  Cancel the context given to New to stop the
+// generator sooner.
+//
+//	err := generator.ForEach(generator.New(ctx, o), func(e interface{}) error {
+//	  // some nice handling
+//	  return nil
+//	})
+func ForEach(ch <-chan Unit, fn func(e interface{}) error) error {
+	defer Drain(ch)
+
+	for unit := range ch {
+		if unit.Err != nil {
+			return errors.WithStack(unit.Err)
+		}
+
+		for _, e := range unit.Entities {
+			if err := fn(e); err != nil {
+				return errors.Wrap(err, "error in fn")
+			}
+		}
+	}
+
+	return nil
+}
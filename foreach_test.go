@@ -0,0 +1,59 @@
+package generator
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestForEach(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	o := &Options{
+		Appender:  appender,
+		ChunkSize: chunkSize,
+		ParentKey: parentKey,
+		Query:     datastore.NewQuery("testHoge").Ancestor(parentKey).Filter("Name =", "Fuga Hogeo"),
+	}
+
+	count := 0
+	if err := ForEach(New(ctx, o), func(e interface{}) error {
+		if _, ok := e.(*testHoge); !ok {
+			return errors.Errorf("entity is %T", e)
+		}
+		count++
+		return nil
+	}); err != nil {
+		t.Fatalf("error in ForEach: %+v", err)
+	}
+	if count != allFugas {
+		t.Fatalf("number differs => expected: %d, result: %d", allFugas, count)
+	}
+
+	stopErr := errors.New("stop")
+	count = 0
+	ch := New(ctx, o)
+	err = ForEach(ch, func(e interface{}) error {
+		count++
+		if count == 3 {
+			return stopErr
+		}
+		return nil
+	})
+	if errors.Cause(err) != stopErr || count != 3 {
+		t.Fatalf("ForEach does not stop => err: %v, count: %d", err, count)
+	}
+	if _, ok := <-ch; ok {
+		t.Fatalf("channel is not drained")
+	}
+}
@@ -40,115 +40,913 @@
 package generator
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"reflect"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/mjibson/goon"
 	"github.com/pkg/errors"
 	"golang.org/x/net/context"
 	"google.golang.org/appengine"
 	"google.golang.org/appengine/datastore"
-	"google.golang.org/appengine/log"
 )
 
-// Appender is needed to create entity for real.
+// Appender is needed to create entity for real.  If it panics, the panic is
+// yielded as an error and the query stops.  AfterLoad, Predicate, ShouldStop
+// and Transform in Options are protected as well.
 type Appender func(ctx context.Context, entities []interface{}, i int, k *datastore.Key, parentKey *datastore.Key) []interface{}
 
 // Options is options for Generator
 type Options struct {
+	// AdaptiveFetch means the chunk size is tuned by the latency of GetMulti.
+	// It starts from a tenth of ChunkSize, grows by that while GetMulti is
+	// fast, and halves when it is slow or fails.  ChunkSize is the max.
+	AdaptiveFetch bool
+	// AfterLoad is called for each entity after GetMulti, before the other
+	// stages such as Predicate and Transform.  It can change the entity in
+	// place.  An error of AfterLoad fails the chunk as GetMulti does.
+	AfterLoad func(ctx context.Context, e interface{}) error
+	// AlignToEntityGroups means a chunk never splits an entity group.  The
+	// chunk is extended beyond ChunkSize until the keys in the same root
+	// ancestor end.  This assumes the keys in a group are contiguous in the
+	// result, as they are in queries ordered by the key.
+	AlignToEntityGroups bool
 	// Appender is needed to create entity for real.
 	Appender Appender
+	// BucketWindow is a number of entities to be buffered for TimeBucket.
+	// The default value is ChunkSize.
+	BucketWindow int
+	// ChangedSince returns the ContentHash of the entity in the prior
+	// snapshot for the encoded key.  If this is set, only entities that are
+	// new or whose hash differs from the prior one are yielded.
+	ChangedSince func(key string) (priorHash string, known bool)
+	// Chaos injects errors and latency into the scan and GetMulti of each
+	// chunk for tests.  It is not used if this is nil.
+	Chaos *Chaos
+	// Checkpoint is called with the cursor where the query can resume, after
+	// every CheckpointEvery chunks are yielded.  The chunks before the cursor
+	// have all been yielded, so the cursor can be persisted for StartCursor of
+	// a restart.  With Priority or TimeBucket, some of them may be still
+	// buffered.  It is called once more at the end, and never concurrently.
+	Checkpoint func(cursor string)
+	// CheckpointEvery is the number of chunks between calls of Checkpoint.
+	// The default value is 1.
+	CheckpointEvery int
+	// ChunkIDFunc makes ChunkID in Unit from the keys in the chunk.  The
+	// default is the SHA-1 hash of the sorted encoded keys.
+	ChunkIDFunc func(keys []*datastore.Key) string
 	// ChunkSize is a number of entities that a returned chunk has.  The
 	// default value is 100.
 	ChunkSize int
+	// ChunkTimeout is the timeout for GetMulti of each chunk.  The chunk
+	// fails with context.DeadlineExceeded on timeout, which is retried with
+	// MaxRetries.  It has no timeout if this is zero.
+	ChunkTimeout time.Duration
+	// CircuitBreaker stops calling the datastore after failures in a row.
+	// Next and GetMulti fail with ErrCircuitOpen while it is open.
+	CircuitBreaker *CircuitBreaker
+	// ContinueOnError means it yields the errors of GetMulti for each chunk and
+	// keeps fetching the other chunks.  Errors of the query still stop it.
+	ContinueOnError bool
+	// Dedup drops the keys that have been yielded in the run, or in any
+	// pipeline of the same Merge, before GetMulti.  The keys are kept in
+	// SeenSet, which grows with every key of the query by default.
+	Dedup bool
+	// EntityType is the type of entities to make for keys if Appender is not
+	// set.  The fields tagged with goon:"id" and goon:"parent" are set from
+	// the key.
+	EntityType reflect.Type
+	// EventualConsistency means the query is run with eventual consistency.
+	// It is faster but may return stale results.  GetMulti is not affected
+	// because lookups by keys are always strongly consistent.
+	EventualConsistency bool
+	// ExcludeFilter skips the keys it contains before Appender.  The keys are
+	// given as encoded strings.  BloomFilter can be used for large sets.
+	ExcludeFilter KeyFilter
+	// FailIfAnyMismatch makes Err of Generator return SchemaDriftError after
+	// the run if ErrFieldMismatch has been ignored by IgnoreErrFieldMismatch.
+	// The stream is the same as without this.  It works only with Generator.
+	FailIfAnyMismatch bool
+	// Goon is used for the query and GetMulti instead of a new Goon from the
+	// context, so they share its cache.  Its local cache keeps every entity
+	// loaded in the run unless MaxLocalCacheEntries is set.  It cannot be
+	// used with Namespace, because the namespace of its context is used.  A
+	// new Goon is still used for ChunkTimeout.
+	Goon *goon.Goon
+	// Heartbeat is the interval to yield a Unit of UnitHeartbeat.  If this is
+	// set, a Unit of UnitDone is yielded at the end.
+	Heartbeat time.Duration
+	// HookConcurrency is the max number of AfterLoad and Transform running
+	// at the same time in the run.  They run in parallel for the entities in
+	// a chunk then.  If this is zero, they run one by one in each chunk,
+	// while the chunks run concurrently up to MaxConcurrency.
+	HookConcurrency int
 	// IgnoreErrFieldMismatch means it ignore ErrFieldMismatch error in
-	// fetching.  And it logs that with log.Warnings() func.
+	// fetching.  And it logs that with Logger.
 	IgnoreErrFieldMismatch bool
+	// IgnoreErrNoSuchEntity means it ignores ErrNoSuchEntity in fetching,
+	// and drops the entities.  It logs that as IgnoreErrFieldMismatch does.
+	IgnoreErrNoSuchEntity bool
+	// IncludeKind means it sets Kinds in Unit.
+	IncludeKind bool
+	// IsRetryable tells whether the error should be retried up to
+	// MaxRetries.  The default retries timeouts,
+	// datastore.ErrConcurrentTransaction and ErrChaos.  MultiError is never
+	// retried.
+	IsRetryable func(err error) bool
+	// KeysOnly means Entities in Unit have the keys as *datastore.Key
+	// without GetMulti.  Appender, ChangedSince and IncludeKind are not used.
+	KeysOnly bool
+	// Limit is the max number of keys to yield in total.  The last chunk
+	// may be smaller than ChunkSize, even with AlignToEntityGroups.  It is
+	// not limited if this is zero.
+	Limit int
+	// Logger logs warnings.  The default is the log of App Engine.
+	Logger Logger
+	// Manifest means Generator records every chunk.  It is available with
+	// Generator.Manifest().
+	Manifest bool
+	// MaxConcurrency is the max number of GetMulti running at the same
+	// time.  If it is reached, the query waits.  It is not limited if this
+	// is zero.
+	MaxConcurrency int
+	// MaxLocalCacheEntries flushes the local cache of Goon every time this
+	// number of entities have been loaded.  The cache grows with every
+	// entity of the run if a Goon is shared by the chunks, as with Goon or
+	// Generator.  It is not flushed if this is zero.
+	MaxLocalCacheEntries int
+	// MaxQPS is the max number of GetMulti calls per second.  Over this, the
+	// calls wait for their turn instead of failing.  It is not limited if this
+	// is zero.
+	MaxQPS float64
+	// MaxRetries is the max number of retries for Next and GetMulti when
+	// they fail with an error that IsRetryable tells.  It does not retry if
+	// this is zero.
+	MaxRetries int
+	// Namespace is the namespace to run the query and GetMulti in.  ParentKey
+	// is made again in this namespace.  The namespace of ctx is used if this
+	// is empty.
+	Namespace string
+	// Offset is the number of keys to skip at the start of the query.  It
+	// cannot be used with StartCursor.
+	Offset int
+	// NoCache means GetMulti of the datastore is used instead of Goon, so
+	// that the local cache and memcache are skipped.  It suits a batch that
+	// reads each entity once.  The keys are made from entities as Goon does.
+	NoCache bool
+	// OnError is called with every error that the query and GetMulti meet,
+	// including the errors that are ignored or retried.  It is called before
+	// the error is yielded or swallowed in the goroutine that meets it, so it
+	// may be called concurrently by GetMulti of some chunks.
+	OnError func(err error)
+	// OnProgress is called with the number of entities fetched so far after
+	// each chunk is fetched.  It is not called concurrently, and the number
+	// increases monotonically.  It should return quickly because GetMulti
+	// of other chunks waits for it.
+	OnProgress func(fetched int)
+	// OutputBuffer is the number of chunks that GetMulti can yield ahead of
+	// the consumer.  They take memory up to OutputBuffer times ChunkSize
+	// entities.  It has no effect with KeysOnly or Project.
+	OutputBuffer int
 	// ParentKey means the key of the parent entity that should be specified if
 	// needed.
 	ParentKey *datastore.Key
+	// PreserveOrder means it yields chunks in the query order.  GetMulti
+	// still runs concurrently, and chunks fetched early are buffered.
+	PreserveOrder bool
+	// Predicate tells whether the entity is yielded after it is loaded.
+	// Chunks whose entities are all dropped are not yielded.
+	Predicate func(e interface{}) bool
+	// Priority returns the priority of the entity.  If this is set, entities
+	// are buffered up to PriorityWindow and yielded from the highest
+	// priority in each window.
+	Priority func(e interface{}) int
+	// PriorityWindow is a number of entities to be buffered for Priority.
+	// The default value is ChunkSize.
+	PriorityWindow int
+	// Project is the properties to load with a projection query.  If this is
+	// set, the query loads them into the entities from Appender, and GetMulti
+	// is not used, so Predicate and Transform cannot be set.  The properties
+	// must be indexed, and the query cannot be KeysOnly.  An entity with a
+	// multiple-valued property is yielded for each value.
+	Project []string
 	// Query is the query to execute.
 	Query *datastore.Query
+	// QuotaCooldown is the duration to pause when the datastore returns an
+	// over quota error.  The query or GetMulti is retried after that, instead
+	// of failing.  It is not paused if this is zero.
+	QuotaCooldown time.Duration
+	// RetryBackoff is the duration to wait before the first retry.  It
+	// doubles for each retry.  The default value is 100 milliseconds.
+	RetryBackoff time.Duration
+	// SeenSet is the set of keys for Dedup.  The default is a map in
+	// memory for the run.  Merge shares one set among its pipelines.
+	SeenSet SeenSet
+	// Sequence means it sets Seq in Unit.  This implies PreserveOrder.  Seq
+	// is not set with Priority or TimeBucket.
+	Sequence bool
+	// ShouldStop is called with the entities of each chunk before GetMulti.
+	// If it returns true, the chunk is the last one and the query stops
+	// without cancelling the context.
+	ShouldStop func(entities []interface{}) bool
+	// StartCursor is the cursor to start the query from.  Cursor in Unit
+	// can be given to resume the query.
+	StartCursor string
+	// Stats is updated with the numbers of the run if this is set.
+	Stats *Stats
+	// StopBeforeDeadline stops the query this long before the deadline of
+	// the context, so that the request is not killed in a chunk.  A Unit of
+	// UnitStopped is yielded then after the other Units, and its Cursor can
+	// be given to StartCursor to resume.  It does nothing if the context has
+	// no deadline.
+	StopBeforeDeadline time.Duration
+	// Summary means a Unit of UnitDone with Summary is yielded at last if the
+	// stream ends without the context cancelled.
+	Summary bool
+	// Tail means it continues to yield new entities after the current
+	// results end.  It waits TailInterval and queries again from the end,
+	// until the context is cancelled.  Query is ordered by WatermarkField so
+	// that new entities come after the end.
+	Tail bool
+	// TailInterval is the interval to query again in Tail.  The default
+	// value is 10 seconds.
+	TailInterval time.Duration
+	// TimeBucket returns the bucket of the entity, such as the hour derived
+	// from its timestamp.  If this is set, entities are buffered up to
+	// BucketWindow and yielded in a Unit for each bucket.  Entities in the
+	// same bucket may be yielded in some Units if the query is not sorted by
+	// the bucket, so BucketWindow should be large enough for such a query.
+	TimeBucket func(e interface{}) string
+	// Tracer starts spans for the run, each chunk of the query and each
+	// GetMulti.  It is not traced if this is nil.
+	Tracer Tracer
+	// TraceSampleRate is the fraction of chunks traced by Tracer.  The spans
+	// for the query and GetMulti of the other chunks are not started, while
+	// the span for the run always is.  The chunks are sampled evenly by
+	// their indices.  Every chunk is traced if this is zero.
+	TraceSampleRate float64
+	// Transform converts each entity after it is loaded.  Entities in Unit
+	// are the converted values.  An error of Transform fails the chunk as
+	// GetMulti does.
+	Transform func(e interface{}) (interface{}, error)
+	// WatermarkField is the property that increases for new entities, such
+	// as a creation time.  This is used with Tail.  Query should not have
+	// other orders.
+	WatermarkField string
 }
 
 // Unit will be returned by generator
 type Unit struct {
 	Entities []interface{}
 	Err      error
+	// Keys has the keys of Entities in the same order.  Entities may not
+	// have the full keys such as the namespace and the parent.
+	Keys []*datastore.Key
+	// Kinds has the kinds of Entities in the same order if IncludeKind is
+	// set in Options.
+	Kinds []string
+	// ChunkID is a stable ID for the keys in the chunk.  This is made by
+	// ChunkIDFunc in Options.  It is empty for Units yielded by Priority or
+	// TimeBucket.
+	ChunkID string
+	// Bucket is the bucket of Entities if TimeBucket is set in Options.
+	Bucket string
+	// Cursor is the cursor at the end of the chunk.  The query resumes after
+	// the chunk with this as StartCursor in Options.  It should be saved
+	// with PreserveOrder because chunks may be yielded out of order.  It is
+	// empty for Units yielded by Priority or TimeBucket.
+	Cursor string
+	// Kind tells what the Unit is.  UnitHeartbeat is yielded only if
+	// Heartbeat is set in Options, UnitDone if Heartbeat or Summary is, and
+	// UnitStopped if StopBeforeDeadline is.
+	Kind UnitKind
+	// Seq is the sequence number of the first entity in Entities if
+	// Sequence is set in Options.  The entity at j has Seq+j.  It starts
+	// with 0 and is the same for the same keys in every run.
+	Seq int
+	// Summary has the totals of the stream in the Unit of UnitDone if
+	// Summary is set in Options.
+	Summary *Summary
+	// Attempts is the number of GetMulti calls for the chunk, including
+	// retries.  It is 0 for Units yielded without GetMulti, such as by
+	// KeysOnly, Priority or TimeBucket.
+	Attempts int
+
+	meta *chunkMeta
 }
 
-const defaultChunkSize = 100
+// chunkMeta is the information of the chunk made in query.
+type chunkMeta struct {
+	index      int
+	keys       int
+	start, end string
+	// filtered means Predicate has dropped all entities.
+	filtered bool
+	// feedback is where getMulti sends the result for AdaptiveFetch.
+	feedback chan<- fetchFeedback
+	// stopped means the query has stopped after this chunk for
+	// StopBeforeDeadline.
+	stopped bool
+	// traced means the chunk is sampled by TraceSampleRate.
+	traced bool
+}
+
+const (
+	defaultChunkSize    = 100
+	defaultTailInterval = 10 * time.Second
+)
 
 // New returns a channel that does as a generator to yield a chunk of entities
 // and an error if exists.  The number of entities in the chunk is specified by
-// ChunkSize in Options.
+// ChunkSize in Options.  Only the first error is yielded, and the chunks being
+// fetched then are dropped.
 func New(ctx context.Context, o *Options) <-chan Unit {
+	return run(ctx, nil, withDefaults(ctx, o), nil)
+}
+
+// NewChecked is New that validates o first.  It returns an error for Options
+// that New would run with a dummy query or fail in the query, such as options
+// that cannot be used together.
+func NewChecked(ctx context.Context, o *Options) (<-chan Unit, error) {
+	if err := validate(o); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return New(ctx, o), nil
+}
+
+func validate(o *Options) error {
+	switch {
+	case o == nil:
+		return errors.New("Options is nil")
+	case o.Query == nil:
+		return errors.New("Query is not set")
+	case o.Appender == nil && o.EntityType == nil && !o.KeysOnly:
+		return errors.New("Appender is not set")
+	case o.ChunkSize < 0:
+		return errors.Errorf("invalid ChunkSize: %d", o.ChunkSize)
+	case o.Limit < 0:
+		return errors.Errorf("invalid Limit: %d", o.Limit)
+	case o.OutputBuffer < 0:
+		return errors.Errorf("invalid OutputBuffer: %d", o.OutputBuffer)
+	case o.Offset < 0:
+		return errors.Errorf("invalid Offset: %d", o.Offset)
+	case o.TraceSampleRate < 0 || o.TraceSampleRate > 1:
+		return errors.Errorf("invalid TraceSampleRate: %v", o.TraceSampleRate)
+	}
+
+	if o.StartCursor != "" {
+		if _, err := datastore.DecodeCursor(o.StartCursor); err != nil {
+			return errors.Wrap(err, "invalid StartCursor")
+		}
+	}
+
+	return conflict(o)
+}
+
+// conflict returns an error if o has options that cannot be used together.
+// New yields it before running the query.
+func conflict(o *Options) error {
+	// the stages after GetMulti do not run without GetMulti.
+	noGetMulti := ""
+	if o.KeysOnly {
+		noGetMulti = "KeysOnly"
+	} else if len(o.Project) > 0 {
+		noGetMulti = "Project"
+	}
+
+	switch {
+	case o.Offset > 0 && o.StartCursor != "":
+		return errors.New("Offset cannot be used with StartCursor")
+	case o.KeysOnly && len(o.Project) > 0:
+		return errors.New("Project cannot be used with KeysOnly")
+	case noGetMulti != "" && o.AfterLoad != nil:
+		return errors.Errorf("AfterLoad cannot be used with %s", noGetMulti)
+	case noGetMulti != "" && o.Transform != nil:
+		return errors.Errorf("Transform cannot be used with %s", noGetMulti)
+	case noGetMulti != "" && o.Predicate != nil:
+		return errors.Errorf("Predicate cannot be used with %s", noGetMulti)
+	case o.Priority != nil && (o.PreserveOrder || o.Sequence):
+		return errors.New("Priority cannot be used with PreserveOrder or Sequence")
+	case o.TimeBucket != nil && (o.PreserveOrder || o.Sequence):
+		return errors.New("TimeBucket cannot be used with PreserveOrder or Sequence")
+	case o.Goon != nil && o.Namespace != "":
+		return errors.New("Goon cannot be used with Namespace")
+	}
+
+	return nil
+}
+
+func withDefaults(ctx context.Context, o *Options) *Options {
 	if o == nil {
 		o = &Options{
 			ChunkSize: defaultChunkSize,
 			Query:     datastore.NewQuery("__DUMMY__"),
 		}
-		log.Warningf(ctx, "set dummy query")
+		logger(o).Warningf(ctx, "set dummy query")
 	} else if o.ChunkSize == 0 {
 		o.ChunkSize = defaultChunkSize
 	} else if o.Query == nil {
 		o.Query = datastore.NewQuery("__DUMMY__")
-		log.Warningf(ctx, "set dummy query")
+		logger(o).Warningf(ctx, "set dummy query")
+	}
+
+	if o.Appender == nil && o.EntityType != nil {
+		o.Appender = entityAppender(o.EntityType)
+	}
+
+	return o
+}
+
+// run starts the pipeline.  If g is nil, each stage uses a new Goon for every
+// chunk.  If m is not nil, chunks are recorded to it.
+func run(ctx context.Context, g *goon.Goon, o *Options, m *manifest) <-chan Unit {
+	err := conflict(o)
+	if err == nil {
+		ctx, err = withNamespace(ctx, o)
+	}
+	if err != nil {
+		reportError(o, err)
+		return errorChannel(err)
 	}
 
-	in := query(ctx, o)
-	out := getMulti(ctx, in, o)
+	ctx, span := startSpan(ctx, o, "generator.Run")
+	if g == nil {
+		g = o.Goon
+	}
 
+	var out <-chan Unit
+	if !o.KeysOnly && len(o.Project) == 0 {
+		// the query is stopped by getMulti at the first error.
+		qctx, stop := context.WithCancel(ctx)
+		out = getMulti(ctx, g, query(qctx, g, o), o, stop)
+	} else {
+		out = query(ctx, g, o)
+	}
+	if o.PreserveOrder || o.Sequence {
+		out = sequence(out)
+	}
+	if m != nil {
+		out = m.record(out)
+	}
+	if o.Checkpoint != nil {
+		out = checkpoint(out, o)
+	}
+	if o.StopBeforeDeadline > 0 {
+		out = yieldStopped(ctx, out)
+	}
+	if o.Predicate != nil {
+		out = dropFiltered(out)
+	}
+	if o.Priority != nil {
+		out = prioritize(ctx, out, o)
+	}
+	if o.TimeBucket != nil {
+		out = bucketize(ctx, out, o)
+	}
+	if o.Heartbeat > 0 {
+		out = heartbeat(out, o)
+	}
+	if o.Summary {
+		out = summarize(ctx, out)
+	}
+	if o.Tracer != nil {
+		out = endSpan(out, span)
+	}
+
+	return out
+}
+
+// errorChannel returns a closed channel that has only a Unit of err.
+func errorChannel(err error) <-chan Unit {
+	out := make(chan Unit, 1)
+	out <- Unit{Err: err, Kind: UnitError}
+	close(out)
 	return out
 }
 
-func query(ctx context.Context, o *Options) <-chan Unit {
+// Generator is a generator that can run many times.  It reuses the same Goon
+// instance across runs.  It is not safe to run concurrently.  All chunks of a
+// run share the Goon, so its local cache grows with every entity loaded in
+// the run.  Set MaxLocalCacheEntries to bound it.
+type Generator struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	ch       <-chan Unit
+	g        *goon.Goon
+	o        Options
+	manifest manifest
+	errs     errorList
+	drift    driftList
+}
+
+// NewGenerator returns a Generator with the options.  The options are the same
+// as New.
+func NewGenerator(ctx context.Context, o *Options) *Generator {
+	o = withDefaults(ctx, o)
+	ctx, cancel := context.WithCancel(ctx)
+
+	// the error is yielded in Run.
+	gctx, err := withNamespace(ctx, o)
+	if err != nil {
+		gctx = ctx
+	}
+
+	return &Generator{
+		ctx:    ctx,
+		cancel: cancel,
+		g:      fromContext(gctx, o.Goon),
+		o:      *o,
+	}
+}
+
+// Start returns a Generator that has started a run.  The Units can be received
+// from C.  Close should be called after that to release the run.
+func Start(ctx context.Context, o *Options) *Generator {
+	gen := NewGenerator(ctx, o)
+	gen.Run()
+	return gen
+}
+
+// Reset sets the query for the next run.  If q is nil, the next run executes
+// the current query from the start.  It also flushes the local cache of Goon
+// so that the next run loads the current entities.
+func (gen *Generator) Reset(q *datastore.Query) {
+	if q != nil {
+		gen.o.Query = q
+	}
+	gen.g.FlushLocalCache()
+}
+
+// Run starts a new run and returns the channel the same as New.
+func (gen *Generator) Run() <-chan Unit {
+	o := gen.o
+	var m *manifest
+	if o.Manifest {
+		gen.manifest.reset()
+		m = &gen.manifest
+	}
+
+	gen.errs.reset()
+	gen.drift.reset()
+	if o.FailIfAnyMismatch {
+		onError := o.OnError
+		o.OnError = func(err error) {
+			gen.drift.add(err)
+			if onError != nil {
+				onError(err)
+			}
+		}
+	}
+
+	gen.ch = gen.errs.record(run(gen.ctx, gen.g, &o, m))
+	return gen.ch
+}
+
+// C returns the channel of the last run.
+func (gen *Generator) C() <-chan Unit {
+	return gen.ch
+}
+
+// Close stops the last run and drains its channel.  It returns after all
+// goroutines of the run exit.  The Generator cannot run after this.
+func (gen *Generator) Close() {
+	gen.cancel()
+	if gen.ch != nil {
+		Drain(gen.ch)
+	}
+}
+
+// Errors returns the errors yielded in the last run.  It has the errors of
+// all failed chunks with ContinueOnError in Options.
+func (gen *Generator) Errors() []error {
+	return gen.errs.get()
+}
+
+// Err returns the first error yielded in the last run.  If there is none, it
+// returns SchemaDriftError when ErrFieldMismatch has been ignored with
+// FailIfAnyMismatch in Options.  It should be called after the channel is
+// closed.
+func (gen *Generator) Err() error {
+	if errs := gen.errs.get(); len(errs) > 0 {
+		return errs[0]
+	}
+	return gen.drift.err()
+}
+
+// Manifest returns the records of chunks in the last run in the order of the
+// index.  Manifest in Options should be set.
+func (gen *Generator) Manifest() []ChunkRecord {
+	return gen.manifest.get()
+}
+
+// iterator is the part of goon.Iterator that query uses.
+type iterator interface {
+	Next(dst interface{}) (*datastore.Key, error)
+	Cursor() (datastore.Cursor, error)
+}
+
+// runQuery runs q.  This can be replaced in tests.
+var runQuery = func(g *goon.Goon, q *datastore.Query) iterator {
+	return g.Run(q)
+}
+
+// runProjection runs q of a projection query.  It does not use Goon so that
+// the partial entities are not cached.  This can be replaced in tests.
+var runProjection = func(g *goon.Goon, q *datastore.Query) iterator {
+	return q.Run(g.Context)
+}
+
+// fromContext returns g, or a new Goon if g is nil.
+func fromContext(ctx context.Context, g *goon.Goon) *goon.Goon {
+	if g == nil {
+		return goon.FromContext(ctx)
+	}
+	return g
+}
+
+func query(ctx context.Context, g *goon.Goon, o *Options) <-chan Unit {
 	in := make(chan Unit)
 
 	go func() {
 		defer close(in)
 
+		// abort yields err before the first chunk.
+		abort := func(err error) {
+			reportError(o, err)
+			in <- Unit{Err: err, Kind: UnitError, meta: &chunkMeta{}}
+		}
+
 		var cur *datastore.Cursor
+		total := 0
+		attempt := 0
+		seen := o.SeenSet
+		if o.Dedup && seen == nil {
+			seen = newKeySet()
+		}
+		// claimed has the keys that the current chunk has added to seen, so
+		// that they are yielded again when the chunk is made again.
+		claimed := make(map[string]bool)
+		if o.StartCursor != "" {
+			c, err := datastore.DecodeCursor(o.StartCursor)
+			if err != nil {
+				abort(errors.Wrap(err, "error in DecodeCursor"))
+				return
+			}
+			cur = &c
+		}
+
+		base := o.Query
+		if o.EventualConsistency {
+			base = base.EventualConsistency()
+		}
+		if o.Tail && o.WatermarkField != "" {
+			base = base.Order(o.WatermarkField)
+		}
+
+		size := o.ChunkSize
+		stopAt := stopTime(ctx, o)
+		var adaptive *adaptiveSize
+		if o.AdaptiveFetch {
+			adaptive = newAdaptiveSize(o.ChunkSize)
+		}
 
-		for {
-			q := o.Query.KeysOnly()
+		// span is the span of the chunk being made.  It is ended here if the
+		// chunk is made again or the query stops.
+		var span Span
+		defer func() {
+			if span != nil {
+				span.End()
+			}
+		}()
+
+	chunk:
+		for index := 0; ; {
+			if span != nil {
+				span.End()
+			}
+			meta := &chunkMeta{index: index, traced: sampled(o, index)}
+			var sctx context.Context
+			sctx, span = startChunkSpan(ctx, o, meta, "generator.query")
+			span.SetAttribute("chunk", index)
+
+			if adaptive != nil {
+				size = adaptive.next()
+				meta.feedback = adaptive.feedback
+			}
+			q := base.KeysOnly()
+			if len(o.Project) > 0 {
+				q = base.Project(o.Project...)
+			}
 			if cur != nil {
 				q = q.Start(*cur)
+				meta.start = cur.String()
+			} else if o.Offset > 0 {
+				// the cursor after this includes the offset.
+				q = q.Offset(o.Offset)
 			}
 
-			g := goon.FromContext(ctx)
-			t := g.Run(q)
+			start := time.Now()
+			var t iterator
+			if len(o.Project) > 0 {
+				t = runProjection(fromContext(sctx, g), q)
+			} else {
+				t = runQuery(fromContext(sctx, g), q)
+			}
+			t = o.Chaos.iterator(ctx, t, index, attempt)
 			isDone := false
-			entities := make([]interface{}, 0, o.ChunkSize)
-			for i := 0; i < o.ChunkSize; i++ {
-				k, err := t.Next(nil)
+			entities := make([]interface{}, 0, size)
+			keys := make([]*datastore.Key, 0, size)
+			// entityKeys has the key of each entity, while keys has all keys
+			// of the chunk including the excluded ones.
+			entityKeys := make([]*datastore.Key, 0, size)
+			// fresh has the keys of this attempt of the chunk.
+			var fresh map[string]bool
+			if o.Dedup {
+				fresh = make(map[string]bool)
+			}
+			fail := func(err error) {
+				reportError(o, err)
+				meta.keys = len(keys)
+				in <- Unit{Err: errors.WithStack(err), Kind: UnitError, meta: meta}
+			}
+			var last *datastore.Key
+			var next *datastore.Cursor
+			for i := 0; i < size || o.AlignToEntityGroups && last != nil; i++ {
+				// stop in the chunk, which may be large.
+				if ctx.Err() != nil {
+					return
+				}
+				if o.Limit > 0 && total+len(keys) >= o.Limit {
+					break
+				}
+				if !stopAt.IsZero() && !now().Before(stopAt) {
+					meta.stopped = true
+					break
+				}
+				if i >= size {
+					// the next chunk starts from here if k is in another group.
+					c, err := t.Cursor()
+					if err != nil {
+						fail(err)
+						return
+					}
+					next = &c
+				}
+				var k *datastore.Key
+				var props datastore.PropertyList
+				err := o.CircuitBreaker.call(func() (err error) {
+					if len(o.Project) > 0 {
+						k, err = t.Next(&props)
+					} else {
+						k, err = t.Next(nil)
+					}
+					return err
+				})
 				if err == datastore.Done {
 					isDone = true
 					break
+				} else if o.QuotaCooldown > 0 && isOverQuota(err) {
+					// this chunk is made again from the same cursor.
+					if !pauseForQuota(ctx, o, err) {
+						return
+					}
+					continue chunk
+				} else if err != nil && retry(ctx, o, attempt, err) {
+					// this chunk is made again from the same cursor.
+					attempt++
+					continue chunk
 				} else if err != nil {
-					in <- Unit{nil, errors.WithStack(err)}
+					fail(needsIndex(err, q))
 					return
 				}
-				if o.Appender != nil {
-					entities = o.Appender(ctx, entities, i, k, o.ParentKey)
+				if i >= size && !rootKey(k).Equal(rootKey(last)) {
+					break
+				}
+				next = nil
+				last = k
+				if o.ExcludeFilter != nil && o.ExcludeFilter.Contains(k.Encode()) {
+					continue
 				}
+				if o.Dedup {
+					ek := k.Encode()
+					if fresh[ek] {
+						continue
+					}
+					if !claimed[ek] {
+						if !claim(seen, ek) {
+							continue
+						}
+						claimed[ek] = true
+					}
+					fresh[ek] = true
+				}
+				if o.KeysOnly {
+					entities = append(entities, k)
+				} else if o.Appender != nil {
+					n := len(entities)
+					if err := protect("Appender", func() error {
+						entities = o.Appender(ctx, entities, i, k, o.ParentKey)
+						return nil
+					}); err != nil {
+						fail(err)
+						return
+					}
+					if len(o.Project) > 0 && len(entities) > n {
+						loaded, err := loadProjection(ctx, entities, props, o)
+						if err != nil {
+							fail(err)
+							return
+						}
+						entities = loaded
+					}
+				}
+				for len(entityKeys) < len(entities) {
+					entityKeys = append(entityKeys, k)
+				}
+				keys = append(keys, k)
 			}
 
-			if !isDone {
+			if next != nil {
+				cur = next
+				meta.end = cur.String()
+			} else {
 				c, err := t.Cursor()
 				if err != nil {
-					in <- Unit{nil, errors.WithStack(err)}
+					fail(err)
 					return
 				}
 				cur = &c
+				meta.end = cur.String()
+			}
+			meta.keys = len(keys)
+			attempt = 0
+			if len(claimed) > 0 {
+				claimed = make(map[string]bool)
+			}
+			elapsed := time.Since(start)
+			span.SetAttribute("keys", len(keys))
+			span.End()
+			span = nil
+
+			total += len(keys)
+			limited := o.Limit > 0 && total >= o.Limit
+			if limited {
+				isDone = true
+			}
+
+			if meta.stopped {
+				isDone = true
+			}
+
+			stopped := false
+			if o.ShouldStop != nil {
+				if err := protect("ShouldStop", func() error {
+					stopped = o.ShouldStop(entities)
+					return nil
+				}); err != nil {
+					fail(err)
+					return
+				}
+				if stopped {
+					isDone = true
+				}
+			}
+
+			// the next chunk would be the same as this if the cursor does not
+			// advance.
+			if !isDone && index > 0 && meta.end == meta.start {
+				logger(o).Warningf(ctx, "cursor does not advance in chunk %d, so stop the query", index)
+				if len(keys) == 0 {
+					return
+				}
+				isDone = true
 			}
 
 			select {
 			case <-ctx.Done():
 				return
 			default:
-				in <- Unit{entities, nil}
-				if isDone {
+				// in tailing, chunks without new keys are not needed, except
+				// the last one to resume from.
+				if !o.Tail || !isDone || len(keys) > 0 || meta.stopped {
+					o.Stats.addChunk(elapsed)
+					in <- Unit{Entities: entities, Keys: entityKeys, ChunkID: chunkID(keys, o), Cursor: meta.end, meta: meta}
+					index++
+				}
+				if isDone && (!o.Tail || limited || stopped || meta.stopped) {
+					return
+				}
+			}
+
+			if isDone {
+				select {
+				case <-ctx.Done():
 					return
+				case <-time.After(tailInterval(o)):
 				}
 			}
 		}
@@ -157,57 +955,422 @@ func query(ctx context.Context, o *Options) <-chan Unit {
 	return in
 }
 
-func getMulti(ctx context.Context, in <-chan Unit, o *Options) <-chan Unit {
-	out := make(chan Unit)
+var isOverQuota = appengine.IsOverQuota
+
+// pauseForQuota waits for QuotaCooldown after err.  It returns false if ctx
+// is done.
+func pauseForQuota(ctx context.Context, o *Options, err error) bool {
+	reportError(o, err)
+	logger(o).Warningf(ctx, "over quota, so pause for %v", o.QuotaCooldown)
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(o.QuotaCooldown):
+		return true
+	}
+}
+
+func tailInterval(o *Options) time.Duration {
+	if o.TailInterval > 0 {
+		return o.TailInterval
+	}
+	return defaultTailInterval
+}
+
+func chunkID(keys []*datastore.Key, o *Options) string {
+	if o.ChunkIDFunc != nil {
+		return o.ChunkIDFunc(keys)
+	}
+	return defaultChunkID(keys)
+}
+
+func defaultChunkID(keys []*datastore.Key) string {
+	encoded := make([]string, len(keys))
+	for i, k := range keys {
+		encoded[i] = k.Encode()
+	}
+	sort.Strings(encoded)
+
+	h := sha1.New()
+	for _, e := range encoded {
+		io.WriteString(h, e)
+		io.WriteString(h, "\n")
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func rootKey(k *datastore.Key) *datastore.Key {
+	for k.Parent() != nil {
+		k = k.Parent()
+	}
+	return k
+}
+
+func getMulti(ctx context.Context, g *goon.Goon, in <-chan Unit, o *Options, stop context.CancelFunc) <-chan Unit {
+	buffer := o.OutputBuffer
+	if buffer < 0 {
+		buffer = 0
+	}
+	out := make(chan Unit, buffer)
+
+	// ctx is cancelled at the first error so that the others stop, and stop
+	// is called then to stop the query that yields in.
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
 
 	go func() {
 		var wg sync.WaitGroup
 		defer func() {
 			wg.Wait()
+			cancel()
+			stop()
 			close(out)
 		}()
 
+		var sem chan struct{}
+		if o.MaxConcurrency > 0 {
+			sem = make(chan struct{}, o.MaxConcurrency)
+		}
+		limit := newLimiter(o.MaxQPS)
+		flusher := newCacheFlusher(o.MaxLocalCacheEntries)
+		hooks := newHookPool(o.HookConcurrency)
+
+		// send gives up sending if ctx is done.
+		send := func(u Unit) {
+			if ctx.Err() != nil {
+				return
+			}
+			select {
+			case <-ctx.Done():
+			case out <- u:
+			}
+		}
+
+		// progress keeps fetched monotonic for OnProgress.
+		var progress sync.Mutex
+		fetched := 0
+
+		// fail sends only the first error unless ContinueOnError.
+		var once sync.Once
+		fail := func(u Unit) {
+			if o.ContinueOnError {
+				send(u)
+				return
+			}
+			once.Do(func() {
+				cancel()
+				stop()
+				select {
+				case <-parent.Done():
+				case out <- u:
+				}
+			})
+		}
+
+		// failChunk reports err of the chunk u and fails.  The errors from
+		// query have been reported there.
+		failChunk := func(u Unit, err error) {
+			reportError(o, err)
+			fail(Unit{Err: err, ChunkID: u.ChunkID, Kind: UnitError, Attempts: u.Attempts, meta: u.meta})
+		}
+
+		i := 0
 		for u := range in {
 			if u.Err != nil {
-				out <- Unit{nil, errors.WithStack(u.Err)}
+				fail(Unit{Err: errors.WithStack(u.Err), Kind: UnitError, meta: u.meta})
 				return
 			}
 
+			// in is drained without fetching so that query can finish, after
+			// an error or cancel.
+			if ctx.Err() != nil {
+				continue
+			}
+
+			if sem != nil {
+				sem <- struct{}{}
+			}
 			wg.Add(1)
-			go func(u Unit) {
+			go func(i int, u Unit) {
 				defer wg.Done()
+				if sem != nil {
+					defer func() { <-sem }()
+				}
 
 				if len(u.Entities) == 0 {
-					out <- Unit{u.Entities, nil}
+					send(u)
 					return
 				}
 
-				g := goon.FromContext(ctx)
-				if err := g.GetMulti(u.Entities); err != nil {
-					if !o.IgnoreErrFieldMismatch {
-						out <- Unit{nil, errors.WithStack(err)}
+				g := fromContext(ctx, g)
+				var err error
+				begin := time.Now()
+				for attempt := 0; ; attempt++ {
+					if !limit.wait(ctx) {
+						return
+					}
+					err = o.CircuitBreaker.call(func() error {
+						u.Attempts++
+						start := time.Now()
+						defer func() { o.Stats.addGetMulti(time.Since(start)) }()
+
+						sctx, span := startChunkSpan(ctx, o, u.meta, "generator.GetMulti")
+						defer span.End()
+						index := 0
+						if u.meta != nil {
+							index = u.meta.index
+							span.SetAttribute("chunk", index)
+						}
+						span.SetAttribute("entities", len(u.Entities))
+
+						if err := o.Chaos.perturb(sctx, chaosLoad, index, attempt); err != nil {
+							return err
+						}
+						return loadWithTimeout(sctx, g, u.Entities, o)
+					})
+					if err == nil {
+						break
+					}
+					if o.QuotaCooldown > 0 && isOverQuota(err) {
+						if !pauseForQuota(ctx, o, err) {
+							return
+						}
+						attempt--
+						continue
+					}
+					if !retry(ctx, o, attempt, err) {
+						break
+					}
+				}
+				if u.meta != nil && u.meta.feedback != nil {
+					sendFeedback(u.meta.feedback, newFetchFeedback(time.Since(begin), err))
+				}
+				if err != nil {
+					if err := checkAlignment(u.Entities, err); err != nil {
+						failChunk(u, errors.WithStack(err))
+						return
+					}
+
+					filtered, ferr := ignoreErrors(ctx, u.Entities, err, o)
+					if _, ok := ferr.(appengine.MultiError); ok {
+						// ChunkError is not wrapped so that errors.As can
+						// reach the errors in it.
+						failChunk(u, newChunkError(i, ferr))
+						return
+					} else if ferr != nil {
+						failChunk(u, errors.WithStack(ferr))
+						return
+					}
+
+					u.Keys = alignKeys(u.Keys, err)
+					u.Entities = filtered
+				}
+				flusher.add(g, len(u.Entities))
+				o.Stats.addFetched(len(u.Entities))
+
+				if o.AfterLoad != nil {
+					err := hooks.each(len(u.Entities), func(j int) error {
+						return protect("AfterLoad", func() error { return o.AfterLoad(ctx, u.Entities[j]) })
+					})
+					if err != nil {
+						failChunk(u, errors.Wrap(err, "error in AfterLoad"))
 						return
 					}
+				}
 
-					filtered, err := filter(ctx, u.Entities, err)
+				if o.ChangedSince != nil {
+					changed, keys, err := changedSince(g, u.Entities, u.Keys, o.ChangedSince)
 					if err != nil {
-						out <- Unit{nil, errors.WithStack(err)}
+						failChunk(u, errors.WithStack(err))
 						return
 					}
+					u.Entities, u.Keys = changed, keys
+				}
 
-					out <- Unit{filtered, nil}
-					return
+				if o.Predicate != nil {
+					var accepted []interface{}
+					var keys []*datastore.Key
+					if err := protect("Predicate", func() error {
+						accepted, keys = applyPredicate(u, o.Predicate)
+						return nil
+					}); err != nil {
+						failChunk(u, err)
+						return
+					}
+					u.Entities, u.Keys = accepted, keys
+				}
+
+				if o.IncludeKind {
+					u.Kinds = make([]string, len(u.Entities))
+					for j, e := range u.Entities {
+						u.Kinds[j] = g.Kind(e)
+					}
+				}
+
+				if o.Transform != nil {
+					err := hooks.each(len(u.Entities), func(j int) error {
+						return protect("Transform", func() (err error) {
+							u.Entities[j], err = o.Transform(u.Entities[j])
+							return err
+						})
+					})
+					if err != nil {
+						failChunk(u, errors.Wrap(err, "error in Transform"))
+						return
+					}
+				}
+
+				if o.OnProgress != nil {
+					progress.Lock()
+					fetched += len(u.Entities)
+					o.OnProgress(fetched)
+					progress.Unlock()
 				}
 
-				out <- u
-			}(u)
+				send(u)
+			}(i, u)
+			i++
 		}
 	}()
 
 	return out
 }
 
+// loadWithTimeout loads entities in ChunkTimeout if it is set.  A new Goon is
+// used then because Goon has its context.  Batches over the size limit of the
+// datastore are split until they fit.
+func loadWithTimeout(ctx context.Context, g *goon.Goon, entities []interface{}, o *Options) error {
+	load := loadEntities
+	if o.NoCache {
+		load = loadRaw
+	}
+	load = splitOnTooLarge(load)
+
+	if o.ChunkTimeout <= 0 {
+		return loadInBatches(g, entities, load)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, o.ChunkTimeout)
+	defer cancel()
+
+	return loadInBatches(goon.FromContext(ctx), entities, load)
+}
+
+// maxGetMulti is the max number of entities that GetMulti can load at once.
+const maxGetMulti = 1000
+
+// loadInBatches loads entities with load in batches of maxGetMulti.
+// MultiErrors of the batches are merged into one for all entities.
+func loadInBatches(g *goon.Goon, entities []interface{}, load func(g *goon.Goon, entities []interface{}) error) error {
+	if len(entities) <= maxGetMulti {
+		return load(g, entities)
+	}
+
+	var mErr appengine.MultiError
+	for i := 0; i < len(entities); i += maxGetMulti {
+		end := i + maxGetMulti
+		if end > len(entities) {
+			end = len(entities)
+		}
+
+		err := load(g, entities[i:end])
+		if err == nil {
+			continue
+		}
+		bErr, ok := err.(appengine.MultiError)
+		if !ok {
+			return err
+		}
+		if err := checkAlignment(entities[i:end], bErr); err != nil {
+			return err
+		}
+		if mErr == nil {
+			mErr = make(appengine.MultiError, len(entities))
+		}
+		copy(mErr[i:end], bErr)
+	}
+
+	if mErr != nil {
+		return mErr
+	}
+	return nil
+}
+
+// loadEntities loads entities.  This can be replaced in tests.
+var loadEntities = func(g *goon.Goon, entities []interface{}) error {
+	return g.GetMulti(entities)
+}
+
+// loadRaw loads entities with GetMulti of the datastore for NoCache.
+func loadRaw(g *goon.Goon, entities []interface{}) error {
+	keys := make([]*datastore.Key, len(entities))
+	for i, e := range entities {
+		k, err := g.KeyError(e)
+		if err != nil {
+			return errors.Wrapf(err, "error in KeyError for entities[%d]", i)
+		}
+		keys[i] = k
+	}
+	return datastore.GetMulti(g.Context, keys, entities)
+}
+
+// checkAlignment returns an error if err is a MultiError that does not have
+// the same length as entities.  Such an error cannot tell which entity has
+// failed.
+func checkAlignment(entities []interface{}, err error) error {
+	mErr, ok := err.(appengine.MultiError)
+	if !ok || len(mErr) == len(entities) {
+		return nil
+	}
+	return errors.Wrapf(err, "MultiError is not aligned with entities => len(entities): %d, len(mErr): %d", len(entities), len(mErr))
+}
+
+// alignKeys drops the keys of the entities that ignoreErrors has dropped for
+// err.  keys are returned as they are if they are not aligned with err.
+func alignKeys(keys []*datastore.Key, err error) []*datastore.Key {
+	mErr, ok := err.(appengine.MultiError)
+	if !ok || len(mErr) != len(keys) {
+		return keys
+	}
+
+	aligned := make([]*datastore.Key, 0, len(keys))
+	for i, k := range keys {
+		if mErr[i] == nil {
+			aligned = append(aligned, k)
+		}
+	}
+	return aligned
+}
+
+// ignoreErrors drops the entities whose errors are ignored by
+// IgnoreErrFieldMismatch and IgnoreErrNoSuchEntity.  It returns err as it is
+// if neither is set.
+func ignoreErrors(ctx context.Context, entities []interface{}, err error, o *Options) ([]interface{}, error) {
+	if !o.IgnoreErrFieldMismatch && !o.IgnoreErrNoSuchEntity {
+		return entities, err
+	}
+	filtered, ferr := filterErrors(ctx, entities, err, o.IgnoreErrFieldMismatch, o.IgnoreErrNoSuchEntity, logger(o))
+	if ferr == nil {
+		// all errors in err have been ignored.
+		if mErr, ok := err.(appengine.MultiError); ok {
+			for _, e := range mErr {
+				if e != nil {
+					reportError(o, e)
+				}
+			}
+		}
+	}
+	return filtered, ferr
+}
+
 func filter(ctx context.Context, entities []interface{}, err error) ([]interface{}, error) {
+	return filterErrors(ctx, entities, err, true, false, appengineLogger{})
+}
+
+// filterErrors drops the entities that have ErrFieldMismatch if fieldMismatch,
+// and ErrNoSuchEntity if noSuchEntity.  Other errors are returned.
+func filterErrors(ctx context.Context, entities []interface{}, err error, fieldMismatch, noSuchEntity bool, l Logger) ([]interface{}, error) {
 	if len(entities) == 0 || err == nil {
 		return entities, err
 	}
@@ -221,8 +1384,7 @@ func filter(ctx context.Context, entities []interface{}, err error) ([]interface
 		return entities, err
 	}
 
-	if len(entities) != len(mErr) {
-		log.Warningf(ctx, "MultiError has different length => len(entities): %d, len(mErr): %d", len(entities), len(mErr))
+	if err := checkAlignment(entities, mErr); err != nil {
 		return entities, err
 	}
 
@@ -231,8 +1393,12 @@ func filter(ctx context.Context, entities []interface{}, err error) ([]interface
 			filtered = append(filtered, entities[i])
 			continue
 		}
-		if _, ok := mErr[i].(*datastore.ErrFieldMismatch); ok {
-			log.Warningf(ctx, "mErr[%d] is ErrFieldMismatch, but ignore this: %v", i, err)
+		if _, ok := mErr[i].(*datastore.ErrFieldMismatch); ok && fieldMismatch {
+			l.Warningf(ctx, "mErr[%d] is ErrFieldMismatch, but ignore this: %v", i, err)
+			continue
+		}
+		if mErr[i] == datastore.ErrNoSuchEntity && noSuchEntity {
+			l.Warningf(ctx, "mErr[%d] is ErrNoSuchEntity, but ignore this: %v", i, err)
 			continue
 		}
 		return entities, err
@@ -3,8 +3,11 @@ package generator
 import (
 	"fmt"
 	"reflect"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/mjibson/goon"
 	"github.com/pkg/errors"
@@ -105,6 +108,13 @@ func createSampleHoge(ctx context.Context) (*datastore.Key, error) {
 	return parentKey, nil
 }
 
+// int64s sorts int64 values in increasing order.
+type int64s []int64
+
+func (s int64s) Len() int           { return len(s) }
+func (s int64s) Less(i, j int) bool { return s[i] < s[j] }
+func (s int64s) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
 func appender(ctx context.Context, entities []interface{}, i int, k *datastore.Key, parentKey *datastore.Key) []interface{} {
 	return append(entities, &testHoge{
 		ID:     k.IntID(),
@@ -339,8 +349,8 @@ func TestFilterInvalidMultiErrorWithDifferentLength(t *testing.T) {
 
 	entities, err := filter(ctx, someEntities, someErr)
 	entitiesStr := fmt.Sprintf("%s", entities)
-	errStr := fmt.Sprintf("%s", err)
-	if someEntitiesStr != entitiesStr || someErrStr != errStr {
+	causeStr := fmt.Sprintf("%s", errors.Cause(err))
+	if someEntitiesStr != entitiesStr || someErrStr != causeStr || !strings.Contains(err.Error(), "not aligned") {
 		t.Fatalf("entities or err differs")
 	}
 }
@@ -373,9 +383,9 @@ func TestGetMultiWithInvalidError(t *testing.T) {
 	defer cancel()
 
 	in := make(chan Unit)
-	out := getMulti(ctx, in, &Options{IgnoreErrFieldMismatch: true})
+	out := getMulti(ctx, nil, in, &Options{IgnoreErrFieldMismatch: true}, func() {})
 
-	in <- Unit{[]interface{}{1}, nil}
+	in <- Unit{Entities: []interface{}{1}}
 	u := <-out
 
 	errStr := fmt.Sprintf("%s", errors.Cause(u.Err))
@@ -396,7 +406,7 @@ func TestQueryWithCancelled(t *testing.T) {
 	}
 
 	q := datastore.NewQuery("testHoge").Ancestor(parentKey)
-	in := query(ctx, &Options{
+	in := query(ctx, nil, &Options{
 		Query: q,
 	})
 
@@ -407,3 +417,1933 @@ func TestQueryWithCancelled(t *testing.T) {
 		t.Fatalf("in has not been closed")
 	}
 }
+
+// cancellingIterator calls cancel after the n-th Next.
+type cancellingIterator struct {
+	iterator
+	cancel context.CancelFunc
+	n      int
+	calls  *int
+}
+
+func (t *cancellingIterator) Next(dst interface{}) (*datastore.Key, error) {
+	*t.calls++
+	k, err := t.iterator.Next(dst)
+	if *t.calls == t.n {
+		t.cancel()
+	}
+	return k, err
+}
+
+func TestQueryWithCancelledInChunk(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	orig := runQuery
+	defer func() { runQuery = orig }()
+	qctx, qcancel := context.WithCancel(ctx)
+	calls := 0
+	runQuery = func(g *goon.Goon, q *datastore.Query) iterator {
+		return &cancellingIterator{orig(g, q), qcancel, 5, &calls}
+	}
+
+	for range query(qctx, nil, &Options{
+		Appender:  appender,
+		ChunkSize: allHoges * 2,
+		ParentKey: parentKey,
+		Query:     datastore.NewQuery("testHoge").Ancestor(parentKey),
+	}) {
+		t.Fatalf("a Unit is yielded after cancel")
+	}
+
+	if calls != 5 {
+		t.Fatalf("Next is called after cancel: %d", calls)
+	}
+}
+
+func TestAlignToEntityGroups(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	g := goon.FromContext(ctx)
+	sizes := []int{4, 3, 7, 1, 5}
+	for i, size := range sizes {
+		parentKey, err := g.Put(&testParent{ID: int64(i + 1)})
+		if err != nil {
+			t.Fatalf("error in Put: %+v", err)
+		}
+		h := make([]*testHoge, size)
+		for j := range h {
+			h[j] = &testHoge{Parent: parentKey, Name: "Hoge Fugao"}
+		}
+		if _, err := g.PutMulti(h); err != nil {
+			t.Fatalf("error in PutMulti: %+v", err)
+		}
+	}
+
+	ch := New(ctx, &Options{
+		AlignToEntityGroups: true,
+		Appender: func(ctx context.Context, entities []interface{}, i int, k *datastore.Key, parentKey *datastore.Key) []interface{} {
+			return append(entities, &testHoge{ID: k.IntID(), Parent: k.Parent()})
+		},
+		ChunkSize: 5,
+		Query:     datastore.NewQuery("testHoge"),
+	})
+
+	seen := map[int64]int{}
+	count := 0
+	chunk := 0
+	for unit := range ch {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		for _, e := range unit.Entities {
+			h := e.(*testHoge)
+			if c, ok := seen[h.Parent.IntID()]; ok && c != chunk {
+				t.Fatalf("group %d spans chunks %d and %d", h.Parent.IntID(), c, chunk)
+			}
+			seen[h.Parent.IntID()] = chunk
+			count++
+		}
+		chunk++
+	}
+
+	if count != 20 || len(seen) != len(sizes) {
+		t.Fatalf("number differs => count: %d, groups: %d", count, len(seen))
+	}
+}
+
+func TestGeneratorRunTwice(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	gen := NewGenerator(ctx, &Options{
+		Appender:               appender,
+		ChunkSize:              chunkSize,
+		IgnoreErrFieldMismatch: true,
+		ParentKey:              parentKey,
+	})
+	g := gen.g
+
+	count := func(ch <-chan Unit) (int, error) {
+		count := 0
+		for unit := range ch {
+			if unit.Err != nil {
+				return 0, errors.Wrap(unit.Err, "error in unit")
+			}
+			count += len(unit.Entities)
+		}
+		return count, nil
+	}
+
+	gen.Reset(datastore.NewQuery("testHoge").Ancestor(parentKey))
+	if c, err := count(gen.Run()); err != nil || c != allHoges {
+		t.Fatalf("first run differs => expected: %d, result: %d, err: %+v", allHoges, c, err)
+	}
+
+	gen.Reset(datastore.NewQuery("testHoge").Ancestor(parentKey).Filter("Name =", "Fuga Hogeo"))
+	if c, err := count(gen.Run()); err != nil || c != allFugas {
+		t.Fatalf("second run differs => expected: %d, result: %d, err: %+v", allFugas, c, err)
+	}
+
+	if gen.g != g {
+		t.Fatalf("goon instance is not reused")
+	}
+}
+
+func TestStart(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	gen := Start(ctx, &Options{
+		Appender:               appender,
+		ChunkSize:              1,
+		IgnoreErrFieldMismatch: true,
+		ParentKey:              parentKey,
+		Query:                  datastore.NewQuery("testHoge").Ancestor(parentKey),
+	})
+
+	if unit := <-gen.C(); unit.Err != nil || len(unit.Entities) != 1 {
+		t.Fatalf("first unit differs: %+v", unit)
+	}
+
+	gen.Close()
+
+	if _, ok := <-gen.C(); ok {
+		t.Fatalf("channel has not been closed")
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("parent context is cancelled")
+	}
+}
+
+func TestChunkID(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	chunkIDs := func(q *datastore.Query) (map[string]int, error) {
+		ids := map[string]int{}
+		for unit := range New(ctx, &Options{
+			Appender:               appender,
+			ChunkSize:              chunkSize,
+			IgnoreErrFieldMismatch: true,
+			ParentKey:              parentKey,
+			Query:                  q,
+		}) {
+			if unit.Err != nil {
+				return nil, errors.Wrap(unit.Err, "error in unit")
+			}
+			if unit.ChunkID == "" {
+				return nil, errors.New("ChunkID is empty")
+			}
+			ids[unit.ChunkID] = len(unit.Entities)
+		}
+		return ids, nil
+	}
+
+	q := datastore.NewQuery("testHoge").Ancestor(parentKey)
+	first, err := chunkIDs(q)
+	if err != nil {
+		t.Fatalf("error in chunkIDs: %+v", err)
+	}
+	second, err := chunkIDs(q)
+	if err != nil {
+		t.Fatalf("error in chunkIDs: %+v", err)
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Fatalf("ChunkIDs differ => first: %v, second: %v", first, second)
+	}
+
+	fugas, err := chunkIDs(q.Filter("Name =", "Fuga Hogeo"))
+	if err != nil {
+		t.Fatalf("error in chunkIDs: %+v", err)
+	}
+	for id := range fugas {
+		if _, ok := first[id]; ok {
+			t.Fatalf("ChunkID is the same for different keys: %s", id)
+		}
+	}
+}
+
+func TestDefaultChunkID(t *testing.T) {
+	ctx := context.Background()
+	k1 := datastore.NewKey(ctx, "testHoge", "", 1, nil)
+	k2 := datastore.NewKey(ctx, "testHoge", "", 2, nil)
+	k3 := datastore.NewKey(ctx, "testHoge", "", 3, nil)
+
+	if defaultChunkID([]*datastore.Key{k1, k2}) != defaultChunkID([]*datastore.Key{k2, k1}) {
+		t.Fatalf("ChunkID depends on the order of keys")
+	}
+	if defaultChunkID([]*datastore.Key{k1, k2}) == defaultChunkID([]*datastore.Key{k1, k3}) {
+		t.Fatalf("ChunkID is the same for different keys")
+	}
+}
+
+func TestIncludeKind(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	count := 0
+	for unit := range New(ctx, &Options{
+		Appender:               appender,
+		ChunkSize:              chunkSize,
+		IgnoreErrFieldMismatch: true,
+		IncludeKind:            true,
+		ParentKey:              parentKey,
+		Query:                  datastore.NewQuery("testHoge").Ancestor(parentKey),
+	}) {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		if len(unit.Kinds) != len(unit.Entities) {
+			t.Fatalf("length differs => entities: %d, kinds: %d", len(unit.Entities), len(unit.Kinds))
+		}
+		for _, kind := range unit.Kinds {
+			if kind != "testHoge" {
+				t.Fatalf("kind differs: %s", kind)
+			}
+			count++
+		}
+	}
+
+	if count != allHoges {
+		t.Fatalf("number differs => expected: %d, result: %d", allHoges, count)
+	}
+}
+
+func TestManifest(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	gen := NewGenerator(ctx, &Options{
+		Appender:               appender,
+		ChunkSize:              chunkSize,
+		IgnoreErrFieldMismatch: true,
+		Manifest:               true,
+		ParentKey:              parentKey,
+		Query:                  datastore.NewQuery("testHoge").Ancestor(parentKey),
+	})
+
+	chunks := 0
+	for unit := range gen.Run() {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		chunks++
+	}
+
+	records := gen.Manifest()
+	if len(records) != chunks {
+		t.Fatalf("number of records differs => expected: %d, result: %d", chunks, len(records))
+	}
+
+	keys := 0
+	for i, r := range records {
+		if r.Index != i || r.Err != nil || r.EndCursor == "" {
+			t.Fatalf("records[%d] is invalid: %+v", i, r)
+		}
+		if i == 0 && r.StartCursor != "" || i > 0 && r.StartCursor != records[i-1].EndCursor {
+			t.Fatalf("records[%d] does not start from the previous end: %+v", i, r)
+		}
+		keys += r.Keys
+	}
+
+	// the keys include testOldHoge.
+	if keys != allHoges+1 {
+		t.Fatalf("number of keys differs => expected: %d, result: %d", allHoges+1, keys)
+	}
+
+	gen.o.IgnoreErrFieldMismatch = false
+	for range gen.Run() {
+	}
+
+	failed := 0
+	for _, r := range gen.Manifest() {
+		if r.Err != nil {
+			failed++
+		}
+	}
+	if failed != 1 {
+		t.Fatalf("number of failed chunks differs => expected: 1, result: %d", failed)
+	}
+}
+
+type recordingIterator struct {
+	iterator
+	last *datastore.Cursor
+}
+
+func (t *recordingIterator) Cursor() (datastore.Cursor, error) {
+	c, err := t.iterator.Cursor()
+	*t.last = c
+	return c, err
+}
+
+type stuckIterator struct {
+	iterator
+	cursor datastore.Cursor
+}
+
+func (t *stuckIterator) Cursor() (datastore.Cursor, error) {
+	return t.cursor, nil
+}
+
+func TestQueryWithStuckCursor(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	// the cursor does not advance from the third chunk.
+	orig := runQuery
+	defer func() { runQuery = orig }()
+	runs := 0
+	var last datastore.Cursor
+	runQuery = func(g *goon.Goon, q *datastore.Query) iterator {
+		runs++
+		t := orig(g, q)
+		if runs < 3 {
+			return &recordingIterator{t, &last}
+		}
+		return &stuckIterator{t, last}
+	}
+
+	count := 0
+	for unit := range query(ctx, nil, &Options{
+		Appender:  appender,
+		ChunkSize: chunkSize,
+		ParentKey: parentKey,
+		Query:     datastore.NewQuery("testHoge").Ancestor(parentKey),
+	}) {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		count += len(unit.Entities)
+	}
+
+	if runs != 3 || count != chunkSize*3 {
+		t.Fatalf("query does not stop => runs: %d, count: %d", runs, count)
+	}
+}
+
+func TestQueryWithZeroChunkSize(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	count := 0
+	for unit := range query(ctx, nil, &Options{
+		Query: datastore.NewQuery("testHoge").Ancestor(parentKey),
+	}) {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		count++
+	}
+
+	if count != 1 {
+		t.Fatalf("number of chunks differs => expected: 1, result: %d", count)
+	}
+}
+
+func TestGetMultiWithMisalignedMultiError(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	orig := loadEntities
+	defer func() { loadEntities = orig }()
+	loadEntities = func(g *goon.Goon, entities []interface{}) error {
+		return appengine.MultiError{&datastore.ErrFieldMismatch{FieldName: "OldName"}}
+	}
+
+	for _, ignore := range []bool{false, true} {
+		in := make(chan Unit)
+		out := getMulti(ctx, nil, in, &Options{IgnoreErrFieldMismatch: ignore}, func() {})
+
+		in <- Unit{Entities: []interface{}{&testHoge{ID: 1}, &testHoge{ID: 2}}}
+		close(in)
+		u := <-out
+
+		if u.Entities != nil || u.Err == nil || !strings.Contains(u.Err.Error(), "not aligned") {
+			t.Fatalf("entities or err differs => ignore: %v, unit: %+v", ignore, u)
+		}
+		if _, ok := <-out; ok {
+			t.Fatalf("out has not been closed")
+		}
+	}
+}
+
+type testEvent struct {
+	ID      int64          `datastore:"-" goon:"id"`
+	Parent  *datastore.Key `datastore:"-" goon:"parent"`
+	Created time.Time
+}
+
+func TestTail(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	g := goon.FromContext(ctx)
+	parentKey, err := g.Put(&testParent{ID: 1})
+	if err != nil {
+		t.Fatalf("error in Put: %+v", err)
+	}
+
+	base := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	putEvents := func(from, to int) {
+		e := make([]*testEvent, 0, to-from)
+		for i := from; i < to; i++ {
+			e = append(e, &testEvent{ID: int64(i + 1), Parent: parentKey, Created: base.Add(time.Duration(i) * time.Minute)})
+		}
+		if _, err := g.PutMulti(e); err != nil {
+			t.Fatalf("error in PutMulti: %+v", err)
+		}
+	}
+	putEvents(0, 5)
+
+	tailCtx, tailCancel := context.WithCancel(ctx)
+	ch := New(tailCtx, &Options{
+		Appender: func(ctx context.Context, entities []interface{}, i int, k *datastore.Key, parentKey *datastore.Key) []interface{} {
+			return append(entities, &testEvent{ID: k.IntID(), Parent: k.Parent()})
+		},
+		ChunkSize:      3,
+		Query:          datastore.NewQuery("testEvent").Ancestor(parentKey),
+		Tail:           true,
+		TailInterval:   10 * time.Millisecond,
+		WatermarkField: "Created",
+	})
+
+	receive := func(n int) []int64 {
+		var ids []int64
+		for len(ids) < n {
+			select {
+			case unit := <-ch:
+				if unit.Err != nil {
+					t.Fatalf("error in unit: %+v", unit.Err)
+				}
+				for _, e := range unit.Entities {
+					ids = append(ids, e.(*testEvent).ID)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out => received: %v", ids)
+			}
+		}
+		sort.Sort(int64s(ids))
+		return ids
+	}
+
+	if ids := receive(5); !reflect.DeepEqual(ids, []int64{1, 2, 3, 4, 5}) {
+		t.Fatalf("initial entities differ: %v", ids)
+	}
+
+	putEvents(5, 8)
+	if ids := receive(3); !reflect.DeepEqual(ids, []int64{6, 7, 8}) {
+		t.Fatalf("new entities differ: %v", ids)
+	}
+
+	tailCancel()
+	for range ch {
+	}
+}
+
+type overQuotaIterator struct {
+	iterator
+	err   error
+	count int
+}
+
+func (t *overQuotaIterator) Next(dst interface{}) (*datastore.Key, error) {
+	t.count++
+	if t.count == 3 {
+		return nil, t.err
+	}
+	return t.iterator.Next(dst)
+}
+
+func TestQuotaCooldown(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	quotaErr := errors.New("over quota")
+	origIsOverQuota := isOverQuota
+	defer func() { isOverQuota = origIsOverQuota }()
+	isOverQuota = func(err error) bool { return err == quotaErr }
+
+	// the second run fails once with the over quota error.
+	origRunQuery := runQuery
+	defer func() { runQuery = origRunQuery }()
+	runs := 0
+	runQuery = func(g *goon.Goon, q *datastore.Query) iterator {
+		runs++
+		t := origRunQuery(g, q)
+		if runs == 2 {
+			return &overQuotaIterator{iterator: t, err: quotaErr}
+		}
+		return t
+	}
+
+	cooldown := 20 * time.Millisecond
+	start := time.Now()
+	seen := map[int64]bool{}
+	for unit := range New(ctx, &Options{
+		Appender:      appender,
+		ChunkSize:     chunkSize,
+		ParentKey:     parentKey,
+		Query:         datastore.NewQuery("testHoge").Ancestor(parentKey).Filter("Name =", "Fuga Hogeo"),
+		QuotaCooldown: cooldown,
+	}) {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		for _, e := range unit.Entities {
+			id := e.(*testHoge).ID
+			if seen[id] {
+				t.Fatalf("entity is yielded twice: %d", id)
+			}
+			seen[id] = true
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed < cooldown {
+		t.Fatalf("pipeline does not pause => elapsed: %v", elapsed)
+	}
+	if len(seen) != allFugas {
+		t.Fatalf("number differs => expected: %d, result: %d", allFugas, len(seen))
+	}
+}
+
+func TestMaxConcurrency(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	orig := loadEntities
+	defer func() { loadEntities = orig }()
+	var mu sync.Mutex
+	running, peak := 0, 0
+	loadEntities = func(g *goon.Goon, entities []interface{}) error {
+		mu.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		mu.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return orig(g, entities)
+	}
+
+	const limit = 2
+	count := 0
+	for unit := range New(ctx, &Options{
+		Appender:               appender,
+		ChunkSize:              5,
+		IgnoreErrFieldMismatch: true,
+		MaxConcurrency:         limit,
+		ParentKey:              parentKey,
+		Query:                  datastore.NewQuery("testHoge").Ancestor(parentKey),
+	}) {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		count += len(unit.Entities)
+	}
+
+	if count != allHoges {
+		t.Fatalf("number differs => expected: %d, result: %d", allHoges, count)
+	}
+	if peak > limit {
+		t.Fatalf("too many GetMulti run => limit: %d, max: %d", limit, peak)
+	}
+}
+
+func TestPreserveOrder(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	// earlier chunks take longer to be fetched.
+	orig := loadEntities
+	defer func() { loadEntities = orig }()
+	loadEntities = func(g *goon.Goon, entities []interface{}) error {
+		time.Sleep(time.Duration(100-entities[0].(*testHoge).ID) * 100 * time.Microsecond)
+		return orig(g, entities)
+	}
+
+	var ids []int64
+	for unit := range New(ctx, &Options{
+		Appender:      appender,
+		ChunkSize:     5,
+		ParentKey:     parentKey,
+		PreserveOrder: true,
+		Query:         datastore.NewQuery("testHoge").Ancestor(parentKey).Filter("Name =", "Fuga Hogeo"),
+	}) {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		for _, e := range unit.Entities {
+			ids = append(ids, e.(*testHoge).ID)
+		}
+	}
+
+	if len(ids) != allFugas {
+		t.Fatalf("number differs => expected: %d, result: %d", allFugas, len(ids))
+	}
+	if !sort.IsSorted(int64s(ids)) {
+		t.Fatalf("entities are not in the cursor order: %v", ids)
+	}
+}
+
+func TestStartCursor(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	o := &Options{
+		Appender:      appender,
+		ChunkSize:     chunkSize,
+		ParentKey:     parentKey,
+		PreserveOrder: true,
+		Query:         datastore.NewQuery("testHoge").Ancestor(parentKey).Filter("Name =", "Fuga Hogeo"),
+	}
+
+	// stop after two chunks.
+	seen := map[int64]bool{}
+	stopCtx, stop := context.WithCancel(ctx)
+	ch := New(stopCtx, o)
+	var cursor string
+	for i := 0; i < 2; i++ {
+		unit := <-ch
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		if unit.Cursor == "" {
+			t.Fatalf("Cursor is empty in chunk %d", i)
+		}
+		for _, e := range unit.Entities {
+			seen[e.(*testHoge).ID] = true
+		}
+		cursor = unit.Cursor
+	}
+	stop()
+	for range ch {
+	}
+
+	o.StartCursor = cursor
+	for unit := range New(ctx, o) {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		for _, e := range unit.Entities {
+			id := e.(*testHoge).ID
+			if seen[id] {
+				t.Fatalf("entity is yielded again after resuming: %d", id)
+			}
+			seen[id] = true
+		}
+	}
+
+	if len(seen) != allFugas {
+		t.Fatalf("number differs => expected: %d, result: %d", allFugas, len(seen))
+	}
+
+	o.StartCursor = "invalid cursor"
+	unit := <-New(ctx, o)
+	if unit.Err == nil || !strings.Contains(unit.Err.Error(), "DecodeCursor") {
+		t.Fatalf("error for invalid cursor differs: %v", unit.Err)
+	}
+}
+
+func TestLimit(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	for _, limit := range []int{chunkSize * 2, chunkSize*2 + 3, allFugas + 10} {
+		var sizes []int
+		count := 0
+		for unit := range query(ctx, nil, &Options{
+			Appender:  appender,
+			ChunkSize: chunkSize,
+			Limit:     limit,
+			ParentKey: parentKey,
+			Query:     datastore.NewQuery("testHoge").Ancestor(parentKey).Filter("Name =", "Fuga Hogeo"),
+		}) {
+			if unit.Err != nil {
+				t.Fatalf("error in unit: %+v", unit.Err)
+			}
+			sizes = append(sizes, len(unit.Entities))
+			count += len(unit.Entities)
+		}
+
+		expected := limit
+		if expected > allFugas {
+			expected = allFugas
+		}
+		if count != expected {
+			t.Fatalf("number differs => limit: %d, expected: %d, result: %d, sizes: %v", limit, expected, count, sizes)
+		}
+		for _, size := range sizes {
+			if size == 0 {
+				t.Fatalf("empty chunk is yielded => limit: %d, sizes: %v", limit, sizes)
+			}
+		}
+	}
+}
+
+func TestGetMultiWithCancelled(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	orig := loadEntities
+	defer func() { loadEntities = orig }()
+	var mu sync.Mutex
+	loads := 0
+	loadEntities = func(g *goon.Goon, entities []interface{}) error {
+		mu.Lock()
+		loads++
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	}
+
+	getCtx, getCancel := context.WithCancel(ctx)
+	in := make(chan Unit)
+	out := getMulti(getCtx, nil, in, &Options{}, func() {})
+
+	in <- Unit{Entities: []interface{}{&testHoge{ID: 1}}}
+	getCancel()
+	for i := 0; i < 5; i++ {
+		in <- Unit{Entities: []interface{}{&testHoge{ID: int64(i + 2)}}}
+	}
+	close(in)
+
+	// the fetching goroutine gives up sending while nobody reads.
+	time.Sleep(50 * time.Millisecond)
+
+	select {
+	case u, ok := <-out:
+		if ok {
+			t.Fatalf("unit is sent after cancel: %+v", u)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("out is not closed")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if loads != 1 {
+		t.Fatalf("chunks are fetched after cancel: %d", loads)
+	}
+}
+
+func TestGetMultiWithManyErrors(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	orig := loadEntities
+	defer func() { loadEntities = orig }()
+	loadEntities = func(g *goon.Goon, entities []interface{}) error {
+		return appengine.MultiError{datastore.ErrNoSuchEntity}
+	}
+
+	for n := 0; n < 20; n++ {
+		in := make(chan Unit)
+		out := getMulti(ctx, nil, in, &Options{}, func() {})
+
+		go func() {
+			defer close(in)
+			for i := 0; i < 50; i++ {
+				in <- Unit{Entities: []interface{}{&testHoge{ID: int64(i + 1)}}}
+			}
+		}()
+
+		errs := 0
+		for u := range out {
+			if _, ok := u.Err.(*ChunkError); !ok {
+				t.Fatalf("unit has no ChunkError: %+v", u)
+			}
+			errs++
+		}
+		if errs != 1 {
+			t.Fatalf("number of errors differs => expected: 1, result: %d", errs)
+		}
+	}
+}
+
+type countingIterator struct {
+	iterator
+	mu    *sync.Mutex
+	calls *int
+}
+
+func (t *countingIterator) Next(dst interface{}) (*datastore.Key, error) {
+	t.mu.Lock()
+	*t.calls++
+	t.mu.Unlock()
+	return t.iterator.Next(dst)
+}
+
+func TestGetMultiStopsQuery(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	origQuery, origLoad := runQuery, loadEntities
+	defer func() { runQuery, loadEntities = origQuery, origLoad }()
+	var mu sync.Mutex
+	calls := 0
+	runQuery = func(g *goon.Goon, q *datastore.Query) iterator {
+		return &countingIterator{iterator: origQuery(g, q), mu: &mu, calls: &calls}
+	}
+	loadEntities = func(g *goon.Goon, entities []interface{}) error {
+		return errors.New("error in GetMulti")
+	}
+
+	// the query would tail forever if it does not stop at the error.
+	done := make(chan int)
+	go func() {
+		errs := 0
+		for unit := range New(ctx, &Options{
+			Appender:     appender,
+			ChunkSize:    chunkSize,
+			ParentKey:    parentKey,
+			Query:        datastore.NewQuery("testHoge").Ancestor(parentKey),
+			Tail:         true,
+			TailInterval: time.Millisecond,
+		}) {
+			if unit.Err != nil {
+				errs++
+			}
+		}
+		done <- errs
+	}()
+
+	select {
+	case errs := <-done:
+		if errs != 1 {
+			t.Fatalf("number of errors differs => expected: 1, result: %d", errs)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the query does not stop after the error")
+	}
+
+	mu.Lock()
+	stopped := calls
+	mu.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != stopped {
+		t.Fatalf("Next is called after the stream is closed => before: %d, after: %d", stopped, calls)
+	}
+}
+
+func TestOffset(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	o := &Options{
+		Appender:      appender,
+		ChunkSize:     chunkSize,
+		ParentKey:     parentKey,
+		PreserveOrder: true,
+		Query:         datastore.NewQuery("testHoge").Ancestor(parentKey).Filter("Name =", "Fuga Hogeo"),
+	}
+	ids := func() []int64 {
+		var ids []int64
+		for unit := range New(ctx, o) {
+			if unit.Err != nil {
+				t.Fatalf("error in unit: %+v", unit.Err)
+			}
+			for _, e := range unit.Entities {
+				ids = append(ids, e.(*testHoge).ID)
+			}
+		}
+		return ids
+	}
+
+	all := ids()
+	const offset = 5
+	o.Offset = offset
+	if result := ids(); !reflect.DeepEqual(result, all[offset:]) {
+		t.Fatalf("entities differ => expected: %v, result: %v", all[offset:], result)
+	}
+
+	o.StartCursor = "some cursor"
+	unit := <-New(ctx, o)
+	if unit.Err == nil || !strings.Contains(unit.Err.Error(), "StartCursor") {
+		t.Fatalf("error for Offset with StartCursor differs: %v", unit.Err)
+	}
+}
+
+func TestNewChecked(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	q := datastore.NewQuery("testHoge").Ancestor(parentKey).Filter("Name =", "Fuga Hogeo")
+	ch, err := NewChecked(ctx, &Options{
+		Appender:  appender,
+		ChunkSize: chunkSize,
+		ParentKey: parentKey,
+		Query:     q,
+	})
+	if err != nil {
+		t.Fatalf("error in NewChecked: %+v", err)
+	}
+	count := 0
+	for unit := range ch {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		count += len(unit.Entities)
+	}
+	if count != allFugas {
+		t.Fatalf("number differs => expected: %d, result: %d", allFugas, count)
+	}
+
+	for _, c := range []struct {
+		o        *Options
+		expected string
+	}{
+		{nil, "Options"},
+		{&Options{Appender: appender}, "Query"},
+		{&Options{Query: q}, "Appender"},
+		{&Options{Appender: appender, ChunkSize: -1, Query: q}, "ChunkSize"},
+		{&Options{Appender: appender, Limit: -1, Query: q}, "Limit"},
+		{&Options{Appender: appender, Query: q, TraceSampleRate: 1.5}, "TraceSampleRate"},
+		{&Options{Appender: appender, Offset: 1, Query: q, StartCursor: "cursor"}, "StartCursor"},
+		{&Options{Appender: appender, Query: q, StartCursor: "invalid cursor"}, "StartCursor"},
+		{&Options{KeysOnly: true, Project: []string{"Name"}, Query: q}, "Project"},
+		{&Options{KeysOnly: true, Query: q, Transform: func(e interface{}) (interface{}, error) { return e, nil }}, "Transform"},
+		{&Options{Appender: appender, Predicate: func(e interface{}) bool { return true }, Project: []string{"Name"}, Query: q}, "Predicate"},
+		{&Options{Appender: appender, PreserveOrder: true, Priority: func(e interface{}) int { return 0 }, Query: q}, "Priority"},
+		{&Options{Appender: appender, Query: q, Sequence: true, TimeBucket: func(e interface{}) string { return "" }}, "TimeBucket"},
+		{&Options{Appender: appender, Goon: goon.FromContext(ctx), Namespace: "tenant", Query: q}, "Namespace"},
+	} {
+		ch, err := NewChecked(ctx, c.o)
+		if ch != nil || err == nil || !strings.Contains(err.Error(), c.expected) {
+			t.Fatalf("error differs => expected: %s, result: %v", c.expected, err)
+		}
+	}
+
+	// New yields the conflict before running the query.
+	unit := <-New(ctx, &Options{
+		KeysOnly:  true,
+		Predicate: func(e interface{}) bool { return true },
+		Query:     q,
+	})
+	if unit.Err == nil || !strings.Contains(unit.Err.Error(), "Predicate") {
+		t.Fatalf("error differs => expected: Predicate, result: %v", unit.Err)
+	}
+}
+
+func TestKeysOnly(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	orig := loadEntities
+	defer func() { loadEntities = orig }()
+	var mu sync.Mutex
+	loads := 0
+	loadEntities = func(g *goon.Goon, entities []interface{}) error {
+		mu.Lock()
+		defer mu.Unlock()
+		loads++
+		return nil
+	}
+
+	ch, err := NewChecked(ctx, &Options{
+		ChunkSize: chunkSize,
+		KeysOnly:  true,
+		Query:     datastore.NewQuery("testHoge").Ancestor(parentKey).Filter("Name =", "Fuga Hogeo"),
+	})
+	if err != nil {
+		t.Fatalf("error in NewChecked: %+v", err)
+	}
+
+	count := 0
+	for unit := range ch {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		for _, e := range unit.Entities {
+			if k, ok := e.(*datastore.Key); !ok || k.Kind() != "testHoge" || !k.Parent().Equal(parentKey) {
+				t.Fatalf("entity is not the key: %+v", e)
+			}
+			count++
+		}
+	}
+	if count != allFugas {
+		t.Fatalf("number differs => expected: %d, result: %d", allFugas, count)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if loads != 0 {
+		t.Fatalf("GetMulti is called: %d", loads)
+	}
+}
+
+func TestGetMultiInBatches(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	// the first entity in the second batch is an old one.
+	orig := loadEntities
+	defer func() { loadEntities = orig }()
+	var mu sync.Mutex
+	var sizes []int
+	loadEntities = func(g *goon.Goon, entities []interface{}) error {
+		mu.Lock()
+		defer mu.Unlock()
+		sizes = append(sizes, len(entities))
+		if len(sizes) == 2 {
+			mErr := make(appengine.MultiError, len(entities))
+			mErr[0] = &datastore.ErrFieldMismatch{FieldName: "OldName"}
+			return mErr
+		}
+		return nil
+	}
+
+	const size = 2500
+	entities := make([]interface{}, size)
+	for i := range entities {
+		entities[i] = &testHoge{ID: int64(i + 1)}
+	}
+
+	in := make(chan Unit)
+	out := getMulti(ctx, nil, in, &Options{IgnoreErrFieldMismatch: true}, func() {})
+	in <- Unit{Entities: entities}
+	close(in)
+	u := <-out
+
+	if u.Err != nil {
+		t.Fatalf("error in unit: %+v", u.Err)
+	}
+	if !reflect.DeepEqual(sizes, []int{1000, 1000, 500}) {
+		t.Fatalf("sizes of batches differ: %v", sizes)
+	}
+	if len(u.Entities) != size-1 {
+		t.Fatalf("number differs => expected: %d, result: %d", size-1, len(u.Entities))
+	}
+	for _, e := range u.Entities {
+		if e.(*testHoge).ID == 1001 {
+			t.Fatalf("old entity is not filtered")
+		}
+	}
+}
+
+func TestOnProgress(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	var progress []int
+	for unit := range New(ctx, &Options{
+		Appender:  appender,
+		ChunkSize: 5,
+		OnProgress: func(fetched int) {
+			progress = append(progress, fetched)
+		},
+		ParentKey: parentKey,
+		Query:     datastore.NewQuery("testHoge").Ancestor(parentKey).Filter("Name =", "Fuga Hogeo"),
+	}) {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+	}
+
+	if len(progress) == 0 || progress[len(progress)-1] != allFugas {
+		t.Fatalf("last progress differs => expected: %d, result: %v", allFugas, progress)
+	}
+	if !sort.IntsAreSorted(progress) {
+		t.Fatalf("progress is not monotonic: %v", progress)
+	}
+}
+
+func TestContinueOnError(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	// the second chunk fails.
+	orig := loadEntities
+	defer func() { loadEntities = orig }()
+	loadEntities = func(g *goon.Goon, entities []interface{}) error {
+		if entities[0].(*testHoge).ID == 6 {
+			return errors.New("hoge error")
+		}
+		return orig(g, entities)
+	}
+
+	gen := NewGenerator(ctx, &Options{
+		Appender:        appender,
+		ChunkSize:       5,
+		ContinueOnError: true,
+		ParentKey:       parentKey,
+		PreserveOrder:   true,
+		Query:           datastore.NewQuery("testHoge").Ancestor(parentKey).Filter("Name =", "Hoge Fugao"),
+	})
+
+	count, errs := 0, 0
+	for unit := range gen.Run() {
+		if unit.Err != nil {
+			errs++
+			continue
+		}
+		count += len(unit.Entities)
+	}
+
+	expected := allHoges - allFugas - 5
+	if count != expected || errs != 1 {
+		t.Fatalf("number differs => expected: %d, result: %d, errors: %d", expected, count, errs)
+	}
+	if e := gen.Errors(); len(e) != 1 || errors.Cause(e[0]).Error() != "hoge error" {
+		t.Fatalf("Errors differs: %v", e)
+	}
+}
+
+func TestChunkTimeout(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	// the first GetMulti hangs until the timeout.
+	orig := loadEntities
+	defer func() { loadEntities = orig }()
+	var mu sync.Mutex
+	loads := 0
+	loadEntities = func(g *goon.Goon, entities []interface{}) error {
+		mu.Lock()
+		loads++
+		first := loads == 1
+		mu.Unlock()
+		if first {
+			<-g.Context.Done()
+			return g.Context.Err()
+		}
+		return orig(g, entities)
+	}
+
+	o := &Options{
+		Appender:     appender,
+		ChunkSize:    allFugas,
+		ChunkTimeout: 10 * time.Millisecond,
+		ParentKey:    parentKey,
+		Query:        datastore.NewQuery("testHoge").Ancestor(parentKey).Filter("Name =", "Fuga Hogeo"),
+	}
+
+	var last error
+	for unit := range New(ctx, o) {
+		if unit.Err != nil {
+			last = unit.Err
+		}
+	}
+	if errors.Cause(last) != context.DeadlineExceeded {
+		t.Fatalf("error differs: %v", last)
+	}
+
+	loads = 0
+	o.MaxRetries = 1
+	o.RetryBackoff = time.Millisecond
+	count := 0
+	for unit := range New(ctx, o) {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		count += len(unit.Entities)
+	}
+	if count != allFugas || loads != 2 {
+		t.Fatalf("number differs => count: %d, loads: %d", count, loads)
+	}
+}
+
+func TestTransform(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	o := &Options{
+		Appender:  appender,
+		ChunkSize: chunkSize,
+		ParentKey: parentKey,
+		Query:     datastore.NewQuery("testHoge").Ancestor(parentKey).Filter("Name =", "Fuga Hogeo"),
+		Transform: func(e interface{}) (interface{}, error) {
+			return e.(*testHoge).Name, nil
+		},
+	}
+
+	count := 0
+	for unit := range New(ctx, o) {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		for _, e := range unit.Entities {
+			if e != "Fuga Hogeo" {
+				t.Fatalf("entity is not transformed: %+v", e)
+			}
+			count++
+		}
+	}
+	if count != allFugas {
+		t.Fatalf("number differs => expected: %d, result: %d", allFugas, count)
+	}
+
+	o.Transform = func(e interface{}) (interface{}, error) {
+		return nil, errors.New("hoge error")
+	}
+	var last error
+	for unit := range New(ctx, o) {
+		if unit.Err != nil {
+			last = unit.Err
+		}
+	}
+	if last == nil || errors.Cause(last).Error() != "hoge error" {
+		t.Fatalf("error differs: %v", last)
+	}
+}
+
+func TestIgnoreErrNoSuchEntity(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	orig := loadEntities
+	defer func() { loadEntities = orig }()
+	loadEntities = func(g *goon.Goon, entities []interface{}) error {
+		return appengine.MultiError{
+			nil,
+			&datastore.ErrFieldMismatch{FieldName: "OldName"},
+			datastore.ErrNoSuchEntity,
+			nil,
+		}
+	}
+
+	for _, c := range []struct {
+		fieldMismatch, noSuchEntity bool
+		expected                    []int64
+	}{
+		{false, false, nil},
+		{true, false, nil},
+		{false, true, nil},
+		{true, true, []int64{1, 4}},
+	} {
+		in := make(chan Unit)
+		out := getMulti(ctx, nil, in, &Options{
+			IgnoreErrFieldMismatch: c.fieldMismatch,
+			IgnoreErrNoSuchEntity:  c.noSuchEntity,
+		}, func() {})
+		in <- Unit{Entities: []interface{}{&testHoge{ID: 1}, &testHoge{ID: 2}, &testHoge{ID: 3}, &testHoge{ID: 4}}}
+		close(in)
+		u := <-out
+
+		if c.expected == nil {
+			if u.Err == nil {
+				t.Fatalf("no error => fieldMismatch: %v, noSuchEntity: %v", c.fieldMismatch, c.noSuchEntity)
+			}
+			continue
+		}
+		if u.Err != nil {
+			t.Fatalf("error in unit: %+v", u.Err)
+		}
+		var ids []int64
+		for _, e := range u.Entities {
+			ids = append(ids, e.(*testHoge).ID)
+		}
+		if !reflect.DeepEqual(ids, c.expected) {
+			t.Fatalf("entities differ => expected: %v, result: %v", c.expected, ids)
+		}
+	}
+}
+
+func TestEventualConsistency(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	orig := runQuery
+	defer func() { runQuery = orig }()
+	var queries []*datastore.Query
+	runQuery = func(g *goon.Goon, q *datastore.Query) iterator {
+		queries = append(queries, q)
+		return orig(g, q)
+	}
+
+	q := datastore.NewQuery("testHoge").Ancestor(parentKey)
+	if err := testFetch(ctx, allHoges, &Options{
+		ChunkSize:              allHoges + 1,
+		EventualConsistency:    true,
+		IgnoreErrFieldMismatch: true,
+		ParentKey:              parentKey,
+		Query:                  q,
+	}); err != nil {
+		t.Fatalf("error in testFetch: %+v", err)
+	}
+
+	expected := q.EventualConsistency().KeysOnly()
+	if len(queries) == 0 || !reflect.DeepEqual(queries[0], expected) {
+		t.Fatalf("query is not eventually consistent: %+v", queries)
+	}
+}
+
+func TestOnError(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	var mu sync.Mutex
+	var errs []error
+	onError := func(err error) {
+		mu.Lock()
+		errs = append(errs, err)
+		mu.Unlock()
+	}
+
+	// the ignored ErrFieldMismatch of testOldHoge is reported.
+	if err := testFetch(ctx, allHoges, &Options{
+		IgnoreErrFieldMismatch: true,
+		OnError:                onError,
+		ParentKey:              parentKey,
+		Query:                  datastore.NewQuery("testHoge").Ancestor(parentKey),
+	}); err != nil {
+		t.Fatalf("error in testFetch: %+v", err)
+	}
+	if len(errs) != 1 {
+		t.Fatalf("number of errors differs => expected: 1, result: %d", len(errs))
+	}
+	if _, ok := errs[0].(*datastore.ErrFieldMismatch); !ok {
+		t.Fatalf("error is not ErrFieldMismatch: %v", errs[0])
+	}
+
+	// the yielded error is reported before it is received.
+	errs = nil
+	for unit := range New(ctx, &Options{
+		Appender:  appender,
+		ChunkSize: chunkSize,
+		OnError:   onError,
+		ParentKey: parentKey,
+		Query:     datastore.NewQuery("testHoge").Ancestor(parentKey),
+	}) {
+		if unit.Err != nil {
+			mu.Lock()
+			reported := len(errs)
+			mu.Unlock()
+			if reported == 0 {
+				t.Fatalf("error is not reported: %v", unit.Err)
+			}
+		}
+	}
+}
+
+func TestNoCache(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	orig := loadEntities
+	defer func() { loadEntities = orig }()
+	loadEntities = func(g *goon.Goon, entities []interface{}) error {
+		return errors.New("Goon is used")
+	}
+
+	if err := testFetch(ctx, allHoges, &Options{
+		IgnoreErrFieldMismatch: true,
+		NoCache:                true,
+		ParentKey:              parentKey,
+		Query:                  datastore.NewQuery("testHoge").Ancestor(parentKey),
+	}); err != nil {
+		t.Fatalf("error in testFetch: %+v", err)
+	}
+}
+
+func TestShouldStop(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	for _, tail := range []bool{false, true} {
+		calls := 0
+		count := 0
+		for unit := range New(ctx, &Options{
+			Appender:               appender,
+			ChunkSize:              chunkSize,
+			IgnoreErrFieldMismatch: true,
+			ParentKey:              parentKey,
+			Query:                  datastore.NewQuery("testHoge").Ancestor(parentKey),
+			ShouldStop: func(entities []interface{}) bool {
+				calls++
+				return calls == 3
+			},
+			Tail: tail,
+		}) {
+			if unit.Err != nil {
+				t.Fatalf("error in unit: %+v", unit.Err)
+			}
+			count += len(unit.Entities)
+		}
+
+		if calls != 3 || count != chunkSize*3 {
+			t.Fatalf("query does not stop => tail: %v, calls: %d, count: %d", tail, calls, count)
+		}
+		if ctx.Err() != nil {
+			t.Fatalf("context is cancelled")
+		}
+	}
+}
+
+func TestAfterLoad(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	// testOldHoge is dropped by ErrFieldMismatch before AfterLoad.
+	var mu sync.Mutex
+	calls := map[int64]int{}
+	o := &Options{
+		AfterLoad: func(ctx context.Context, e interface{}) error {
+			h := e.(*testHoge)
+			mu.Lock()
+			calls[h.ID]++
+			mu.Unlock()
+			h.Name = strings.ToUpper(h.Name)
+			return nil
+		},
+		Appender:               appender,
+		ChunkSize:              chunkSize,
+		IgnoreErrFieldMismatch: true,
+		ParentKey:              parentKey,
+		Query:                  datastore.NewQuery("testHoge").Ancestor(parentKey),
+	}
+
+	count := 0
+	for unit := range New(ctx, o) {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		for _, e := range unit.Entities {
+			if h := e.(*testHoge); h.Name != strings.ToUpper(h.Name) {
+				t.Fatalf("AfterLoad is not applied: %+v", h)
+			}
+			count++
+		}
+	}
+	if count != allHoges || len(calls) != allHoges {
+		t.Fatalf("number differs => expected: %d, result: %d, calls: %d", allHoges, count, len(calls))
+	}
+	for id, n := range calls {
+		if n != 1 {
+			t.Fatalf("AfterLoad is called %d times for %d", n, id)
+		}
+	}
+
+	o.AfterLoad = func(ctx context.Context, e interface{}) error {
+		return errors.New("hoge error")
+	}
+	var last error
+	for unit := range New(ctx, o) {
+		if unit.Err != nil {
+			last = unit.Err
+		}
+	}
+	if last == nil || errors.Cause(last).Error() != "hoge error" {
+		t.Fatalf("error differs: %v", last)
+	}
+}
+
+func TestOutputBuffer(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	// all chunks are fetched before the consumer receives one.
+	fetchedAll := make(chan struct{})
+	ch := New(ctx, &Options{
+		Appender:               appender,
+		ChunkSize:              chunkSize,
+		IgnoreErrFieldMismatch: true,
+		MaxConcurrency:         1,
+		OnProgress: func(fetched int) {
+			if fetched == allHoges {
+				close(fetchedAll)
+			}
+		},
+		OutputBuffer: allHoges/chunkSize + 1,
+		ParentKey:    parentKey,
+		Query:        datastore.NewQuery("testHoge").Ancestor(parentKey),
+	})
+
+	select {
+	case <-fetchedAll:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("chunks are not buffered")
+	}
+
+	count := 0
+	for unit := range ch {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		count += len(unit.Entities)
+	}
+	if count != allHoges {
+		t.Fatalf("number differs => expected: %d, result: %d", allHoges, count)
+	}
+}
+
+func TestKeys(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	// testOldHoge is dropped by ErrFieldMismatch, and odd IDs by Predicate.
+	count := 0
+	for unit := range New(ctx, &Options{
+		Appender:               appender,
+		ChunkSize:              chunkSize,
+		IgnoreErrFieldMismatch: true,
+		ParentKey:              parentKey,
+		Predicate:              func(e interface{}) bool { return e.(*testHoge).ID%2 == 0 },
+		Query:                  datastore.NewQuery("testHoge").Ancestor(parentKey),
+	}) {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		if len(unit.Keys) != len(unit.Entities) {
+			t.Fatalf("number of keys differs => expected: %d, result: %d", len(unit.Entities), len(unit.Keys))
+		}
+		for i, e := range unit.Entities {
+			k := unit.Keys[i]
+			if k.IntID() != e.(*testHoge).ID || !k.Parent().Equal(parentKey) {
+				t.Fatalf("key is not aligned => entity: %d, key: %v", e.(*testHoge).ID, k)
+			}
+		}
+		count += len(unit.Entities)
+	}
+	if count == 0 {
+		t.Fatalf("no entities are yielded")
+	}
+}
+
+func TestGoon(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	origQuery, origLoad := runQuery, loadEntities
+	defer func() { runQuery, loadEntities = origQuery, origLoad }()
+	var mu sync.Mutex
+	used := map[*goon.Goon]bool{}
+	runQuery = func(g *goon.Goon, q *datastore.Query) iterator {
+		mu.Lock()
+		used[g] = true
+		mu.Unlock()
+		return origQuery(g, q)
+	}
+	loadEntities = func(g *goon.Goon, entities []interface{}) error {
+		mu.Lock()
+		used[g] = true
+		mu.Unlock()
+		return origLoad(g, entities)
+	}
+
+	g := goon.FromContext(ctx)
+	if err := testFetch(ctx, allHoges, &Options{
+		Appender:               appender,
+		ChunkSize:              chunkSize,
+		Goon:                   g,
+		IgnoreErrFieldMismatch: true,
+		ParentKey:              parentKey,
+		Query:                  datastore.NewQuery("testHoge").Ancestor(parentKey),
+	}); err != nil {
+		t.Fatalf("error in testFetch: %+v", err)
+	}
+
+	if len(used) != 1 || !used[g] {
+		t.Fatalf("Goon in Options is not used: %v", used)
+	}
+}
+
+func TestKeysWithIgnoredErrors(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	// the first entity is an old one.
+	orig := loadEntities
+	defer func() { loadEntities = orig }()
+	loadEntities = func(g *goon.Goon, entities []interface{}) error {
+		mErr := make(appengine.MultiError, len(entities))
+		mErr[0] = &datastore.ErrFieldMismatch{FieldName: "OldName"}
+		return mErr
+	}
+
+	var entities []interface{}
+	var keys []*datastore.Key
+	for i := int64(1); i <= 3; i++ {
+		entities = append(entities, &testHoge{ID: i})
+		keys = append(keys, datastore.NewKey(ctx, "testHoge", "", i, nil))
+	}
+
+	in := make(chan Unit)
+	out := getMulti(ctx, nil, in, &Options{IgnoreErrFieldMismatch: true}, func() {})
+	in <- Unit{Entities: entities, Keys: keys}
+	close(in)
+	u := <-out
+
+	if u.Err != nil {
+		t.Fatalf("error in unit: %+v", u.Err)
+	}
+	if len(u.Keys) != 2 || u.Keys[0].IntID() != 2 || u.Keys[1].IntID() != 3 {
+		t.Fatalf("keys are not aligned: %v", u.Keys)
+	}
+}
+
+func TestFailIfAnyMismatch(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	for _, fail := range []bool{true, false} {
+		gen := NewGenerator(ctx, &Options{
+			Appender:               appender,
+			ChunkSize:              chunkSize,
+			FailIfAnyMismatch:      fail,
+			IgnoreErrFieldMismatch: true,
+			ParentKey:              parentKey,
+			Query:                  datastore.NewQuery("testHoge").Ancestor(parentKey),
+		})
+
+		// the stream has all good entities regardless of the option.
+		count := 0
+		for unit := range gen.Run() {
+			if unit.Err != nil {
+				t.Fatalf("error in unit: %+v", unit.Err)
+			}
+			count += len(unit.Entities)
+		}
+		if count != allHoges {
+			t.Fatalf("number differs => expected: %d, result: %d", allHoges, count)
+		}
+
+		err := gen.Err()
+		if !fail {
+			if err != nil {
+				t.Fatalf("error without FailIfAnyMismatch: %+v", err)
+			}
+			continue
+		}
+		dErr, ok := err.(*SchemaDriftError)
+		if !ok {
+			t.Fatalf("error is not SchemaDriftError: %+v", err)
+		}
+		if dErr.Count != 1 || !reflect.DeepEqual(dErr.Fields, []string{"OldName"}) {
+			t.Fatalf("schema drift differs: %+v", dErr)
+		}
+	}
+}
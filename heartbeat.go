@@ -0,0 +1,67 @@
+package generator
+
+import (
+	"time"
+)
+
+// UnitKind is the kind of Unit.
+type UnitKind int
+
+const (
+	// UnitData is a Unit that has entities.
+	UnitData UnitKind = iota
+	// UnitError is a Unit that has an error.
+	UnitError
+	// UnitHeartbeat is a Unit that has nothing to tell the stream is alive.
+	UnitHeartbeat
+	// UnitDone is the last Unit in the stream.  It has Summary if Summary is
+	// set in Options.
+	UnitDone
+	// UnitStopped is a Unit that tells the query has stopped before the
+	// deadline.  It has the cursor to resume from.
+	UnitStopped
+)
+
+func (k UnitKind) String() string {
+	switch k {
+	case UnitData:
+		return "Data"
+	case UnitError:
+		return "Error"
+	case UnitHeartbeat:
+		return "Heartbeat"
+	case UnitDone:
+		return "Done"
+	case UnitStopped:
+		return "Stopped"
+	}
+	return "Unknown"
+}
+
+// heartbeat yields a Unit of UnitHeartbeat every Heartbeat between the Units
+// from in, and a Unit of UnitDone after in is closed.
+func heartbeat(in <-chan Unit, o *Options) <-chan Unit {
+	out := make(chan Unit)
+
+	go func() {
+		defer close(out)
+
+		ticker := time.NewTicker(o.Heartbeat)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case u, ok := <-in:
+				if !ok {
+					out <- Unit{Kind: UnitDone}
+					return
+				}
+				out <- u
+			case <-ticker.C:
+				out <- Unit{Kind: UnitHeartbeat}
+			}
+		}
+	}()
+
+	return out
+}
@@ -0,0 +1,74 @@
+package generator
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestHeartbeat(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	ch := New(ctx, &Options{
+		Appender: func(ctx context.Context, entities []interface{}, i int, k *datastore.Key, parentKey *datastore.Key) []interface{} {
+			// slow down to get heartbeats between chunks.
+			time.Sleep(2 * time.Millisecond)
+			return appender(ctx, entities, i, k, parentKey)
+		},
+		ChunkSize:              chunkSize,
+		Heartbeat:              5 * time.Millisecond,
+		IgnoreErrFieldMismatch: true,
+		ParentKey:              parentKey,
+		Query:                  datastore.NewQuery("testHoge").Ancestor(parentKey),
+	})
+
+	counts := map[UnitKind]int{}
+	var kinds []UnitKind
+	for unit := range ch {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		if unit.Kind == UnitHeartbeat && len(unit.Entities) > 0 {
+			t.Fatalf("heartbeat has entities: %+v", unit)
+		}
+		counts[unit.Kind]++
+		kinds = append(kinds, unit.Kind)
+	}
+
+	if counts[UnitData] == 0 || counts[UnitHeartbeat] == 0 || counts[UnitDone] != 1 {
+		t.Fatalf("counts differ: %v", counts)
+	}
+	if kinds[len(kinds)-1] != UnitDone {
+		t.Fatalf("last Unit is not Done: %v", kinds)
+	}
+
+	first, last := -1, -1
+	for i, k := range kinds {
+		if k == UnitData {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+	interleaved := false
+	for i := first + 1; i < last; i++ {
+		if kinds[i] == UnitHeartbeat {
+			interleaved = true
+		}
+	}
+	if !interleaved {
+		t.Fatalf("heartbeats are not interleaved: %v", kinds)
+	}
+}
@@ -0,0 +1,49 @@
+package generator
+
+import (
+	"sync"
+)
+
+// hookPool bounds the number of hooks such as Transform running at the same
+// time for HookConcurrency.  It is shared by the chunks of a run.  A nil pool
+// runs hooks one by one in each chunk.
+type hookPool chan struct{}
+
+// newHookPool returns nil if n is not positive.
+func newHookPool(n int) hookPool {
+	if n <= 0 {
+		return nil
+	}
+	return make(hookPool, n)
+}
+
+// each calls fn for 0 to n-1 in the pool and returns the first error.  Without
+// the pool, fn is called in order and it stops at the first error.
+func (p hookPool) each(n int, fn func(j int) error) error {
+	if p == nil {
+		for j := 0; j < n; j++ {
+			if err := fn(j); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var first error
+	for j := 0; j < n; j++ {
+		p <- struct{}{}
+		wg.Add(1)
+		go func(j int) {
+			defer wg.Done()
+			defer func() { <-p }()
+			if err := fn(j); err != nil {
+				once.Do(func() { first = err })
+			}
+		}(j)
+	}
+	wg.Wait()
+
+	return first
+}
@@ -0,0 +1,78 @@
+package generator
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestHookConcurrency(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	var mu sync.Mutex
+	running, peak := 0, 0
+	enter := func() {
+		mu.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		mu.Unlock()
+	}
+	leave := func() {
+		mu.Lock()
+		running--
+		mu.Unlock()
+	}
+
+	count := 0
+	for unit := range New(ctx, &Options{
+		AfterLoad: func(ctx context.Context, e interface{}) error {
+			enter()
+			defer leave()
+			time.Sleep(time.Millisecond)
+			return nil
+		},
+		Appender:               appender,
+		ChunkSize:              chunkSize,
+		HookConcurrency:        2,
+		IgnoreErrFieldMismatch: true,
+		ParentKey:              parentKey,
+		Query:                  datastore.NewQuery("testHoge").Ancestor(parentKey),
+		Transform: func(e interface{}) (interface{}, error) {
+			enter()
+			defer leave()
+			time.Sleep(time.Millisecond)
+			return e.(*testHoge).ID, nil
+		},
+	}) {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		for _, e := range unit.Entities {
+			if _, ok := e.(int64); !ok {
+				t.Fatalf("entity is not transformed: %T", e)
+			}
+			count++
+		}
+	}
+
+	if count != allHoges {
+		t.Fatalf("number differs => expected: %d, result: %d", allHoges, count)
+	}
+	if peak != 2 {
+		t.Fatalf("number of hooks at the same time differs => expected: 2, result: %d", peak)
+	}
+}
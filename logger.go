@@ -0,0 +1,26 @@
+package generator
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+)
+
+// Logger logs warnings of the generator.
+type Logger interface {
+	Warningf(ctx context.Context, format string, args ...interface{})
+}
+
+// appengineLogger is the default Logger that uses the log of App Engine.
+type appengineLogger struct{}
+
+func (appengineLogger) Warningf(ctx context.Context, format string, args ...interface{}) {
+	log.Warningf(ctx, format, args...)
+}
+
+// logger returns Logger in o, or the default one.
+func logger(o *Options) Logger {
+	if o == nil || o.Logger == nil {
+		return appengineLogger{}
+	}
+	return o.Logger
+}
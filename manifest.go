@@ -0,0 +1,77 @@
+package generator
+
+import (
+	"sort"
+	"sync"
+)
+
+// ChunkRecord is a record of a chunk in the manifest.
+type ChunkRecord struct {
+	// Index is the index of the chunk in the stream.
+	Index int
+	// Keys is the number of keys that the chunk has.
+	Keys int
+	// StartCursor is the cursor where the chunk starts.  It is empty for the
+	// first chunk.
+	StartCursor string
+	// EndCursor is the cursor where the chunk ends.  It is empty if the
+	// chunk has failed before reaching its end.
+	EndCursor string
+	// Err is the error of the chunk.  It is nil if the chunk is OK.
+	Err error
+}
+
+type manifest struct {
+	mu      sync.Mutex
+	records []ChunkRecord
+}
+
+func (m *manifest) reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.records = nil
+}
+
+func (m *manifest) get() []ChunkRecord {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	records := make([]ChunkRecord, len(m.records))
+	copy(records, m.records)
+	sort.Sort(byIndex(records))
+
+	return records
+}
+
+// record records every Unit from in before yielding it.
+func (m *manifest) record(in <-chan Unit) <-chan Unit {
+	out := make(chan Unit)
+
+	go func() {
+		defer close(out)
+
+		for u := range in {
+			if u.meta != nil {
+				m.mu.Lock()
+				m.records = append(m.records, ChunkRecord{
+					Index:       u.meta.index,
+					Keys:        u.meta.keys,
+					StartCursor: u.meta.start,
+					EndCursor:   u.meta.end,
+					Err:         u.Err,
+				})
+				m.mu.Unlock()
+			}
+			out <- u
+		}
+	}()
+
+	return out
+}
+
+// byIndex sorts ChunkRecords by Index.
+type byIndex []ChunkRecord
+
+func (r byIndex) Len() int           { return len(r) }
+func (r byIndex) Less(i, j int) bool { return r[i].Index < r[j].Index }
+func (r byIndex) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
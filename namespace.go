@@ -0,0 +1,34 @@
+package generator
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+// withNamespace returns ctx in Namespace, and makes ParentKey again in it.
+func withNamespace(ctx context.Context, o *Options) (context.Context, error) {
+	if o.Namespace == "" {
+		return ctx, nil
+	}
+
+	ctx, err := appengine.Namespace(ctx, o.Namespace)
+	if err != nil {
+		return nil, errors.Wrap(err, "error in Namespace")
+	}
+
+	if o.ParentKey != nil && o.ParentKey.Namespace() != o.Namespace {
+		o.ParentKey = keyInContext(ctx, o.ParentKey)
+	}
+
+	return ctx, nil
+}
+
+// keyInContext makes k and its ancestors again in the namespace of ctx.
+func keyInContext(ctx context.Context, k *datastore.Key) *datastore.Key {
+	if k == nil {
+		return nil
+	}
+	return datastore.NewKey(ctx, k.Kind(), k.StringID(), k.IntID(), keyInContext(ctx, k.Parent()))
+}
@@ -0,0 +1,103 @@
+package generator
+
+import (
+	"testing"
+
+	"github.com/mjibson/goon"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestNamespace(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	sizes := map[string]int{"hoge": 3, "fuga": 5}
+	for ns, size := range sizes {
+		nsCtx, err := appengine.Namespace(ctx, ns)
+		if err != nil {
+			t.Fatalf("error in Namespace: %+v", err)
+		}
+		h := make([]*testHoge, size)
+		for i := range h {
+			h[i] = &testHoge{ID: int64(i + 1), Name: ns}
+		}
+		if _, err := goon.FromContext(nsCtx).PutMulti(h); err != nil {
+			t.Fatalf("error in PutMulti: %+v", err)
+		}
+	}
+
+	for ns, size := range sizes {
+		count := 0
+		for unit := range New(ctx, &Options{
+			Appender: func(ctx context.Context, entities []interface{}, i int, k *datastore.Key, parentKey *datastore.Key) []interface{} {
+				return append(entities, &testHoge{ID: k.IntID()})
+			},
+			ChunkSize: chunkSize,
+			Namespace: ns,
+			Query:     datastore.NewQuery("testHoge"),
+		}) {
+			if unit.Err != nil {
+				t.Fatalf("error in unit: %+v", unit.Err)
+			}
+			for _, e := range unit.Entities {
+				if h := e.(*testHoge); h.Name != ns {
+					t.Fatalf("entity in another namespace => namespace: %s, entity: %+v", ns, h)
+				}
+				count++
+			}
+		}
+		if count != size {
+			t.Fatalf("number differs => namespace: %s, expected: %d, result: %d", ns, size, count)
+		}
+	}
+
+	// the other entry points run in Namespace too.
+	for ns, size := range sizes {
+		o := &Options{
+			Appender: func(ctx context.Context, entities []interface{}, i int, k *datastore.Key, parentKey *datastore.Key) []interface{} {
+				return append(entities, &testHoge{ID: k.IntID()})
+			},
+			Namespace: ns,
+			Query:     datastore.NewQuery("testHoge"),
+		}
+
+		entities, err := Peek(ctx, o, 10)
+		if err != nil {
+			t.Fatalf("error in Peek: %+v", err)
+		}
+		if len(entities) != size || entities[0].(*testHoge).Name != ns {
+			t.Fatalf("Peek runs in another namespace => namespace: %s, entities: %d", ns, len(entities))
+		}
+
+		if err := ValidateAppender(ctx, o, 10); err != nil {
+			t.Fatalf("error in ValidateAppender => namespace: %s, err: %+v", ns, err)
+		}
+
+		m, err := CollectMap(ctx, o)
+		if err != nil {
+			t.Fatalf("error in CollectMap: %+v", err)
+		}
+		for ek := range m {
+			k, err := datastore.DecodeKey(ek)
+			if err != nil {
+				t.Fatalf("error in DecodeKey: %+v", err)
+			}
+			if k.Namespace() != ns {
+				t.Fatalf("key in another namespace => namespace: %s, key: %v", ns, k)
+			}
+		}
+		if len(m) != size {
+			t.Fatalf("number differs => namespace: %s, expected: %d, result: %d", ns, size, len(m))
+		}
+	}
+
+	unit := <-New(ctx, &Options{Namespace: "invalid namespace", Query: datastore.NewQuery("testHoge")})
+	if unit.Err == nil {
+		t.Fatalf("no error for invalid namespace")
+	}
+}
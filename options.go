@@ -0,0 +1,44 @@
+package generator
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// Option sets a field of Options for NewFunc.
+type Option func(o *Options)
+
+// NewFunc returns the channel the same as New with Options made by opts.
+func NewFunc(ctx context.Context, opts ...Option) <-chan Unit {
+	o := &Options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return New(ctx, o)
+}
+
+// WithAppender sets Appender.
+func WithAppender(fn Appender) Option {
+	return func(o *Options) { o.Appender = fn }
+}
+
+// WithFetchLimit sets ChunkSize, the number of entities to fetch with one
+// GetMulti.
+func WithFetchLimit(n int) Option {
+	return func(o *Options) { o.ChunkSize = n }
+}
+
+// WithIgnoreErrFieldMismatch sets IgnoreErrFieldMismatch.
+func WithIgnoreErrFieldMismatch() Option {
+	return func(o *Options) { o.IgnoreErrFieldMismatch = true }
+}
+
+// WithParentKey sets ParentKey.
+func WithParentKey(k *datastore.Key) Option {
+	return func(o *Options) { o.ParentKey = k }
+}
+
+// WithQuery sets Query.
+func WithQuery(q *datastore.Query) Option {
+	return func(o *Options) { o.Query = q }
+}
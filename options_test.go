@@ -0,0 +1,43 @@
+package generator
+
+import (
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+)
+
+func TestNewFunc(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	ch := NewFunc(ctx,
+		WithAppender(appender),
+		WithFetchLimit(chunkSize),
+		WithIgnoreErrFieldMismatch(),
+		WithParentKey(parentKey),
+		WithQuery(datastore.NewQuery("testHoge").Ancestor(parentKey)),
+	)
+
+	count := 0
+	for unit := range ch {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		if len(unit.Entities) > chunkSize {
+			t.Fatalf("chunk is too large: %d", len(unit.Entities))
+		}
+		count += len(unit.Entities)
+	}
+
+	if count != allHoges {
+		t.Fatalf("number differs => expected: %d, result: %d", allHoges, count)
+	}
+}
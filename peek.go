@@ -0,0 +1,71 @@
+package generator
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// Peek returns at most n entities from the head of the query.  It scans only n
+// keys in a single run and loads them with one GetMulti, so it is cheap enough
+// for previews.  Namespace, ExcludeFilter and the Ignore options for errors
+// are honored, but the other stages such as Priority are not.  EntityType and
+// KeysOnly are used as New does.
+func Peek(ctx context.Context, o *Options, n int) ([]interface{}, error) {
+	if o == nil || o.Appender == nil && o.EntityType == nil && !o.KeysOnly {
+		return nil, errors.New("Appender is not set")
+	}
+	if o.Query == nil {
+		return nil, errors.New("Query is not set")
+	}
+	if n <= 0 {
+		return nil, errors.Errorf("invalid number: %d", n)
+	}
+
+	oc := *o
+	o = withDefaults(ctx, &oc)
+	ctx, err := withNamespace(ctx, o)
+	if err != nil {
+		return nil, err
+	}
+
+	g := fromContext(ctx, o.Goon)
+	t := runQuery(g, o.Query.KeysOnly().Limit(n))
+
+	var entities []interface{}
+	for i := 0; len(entities) < n; i++ {
+		k, err := t.Next(nil)
+		if err == datastore.Done {
+			break
+		} else if err != nil {
+			return nil, errors.Wrap(needsIndex(err, o.Query), "error in Next")
+		}
+
+		if o.ExcludeFilter != nil && o.ExcludeFilter.Contains(k.Encode()) {
+			continue
+		}
+
+		if o.KeysOnly {
+			entities = append(entities, k)
+		} else {
+			entities = o.Appender(ctx, entities, i, k, o.ParentKey)
+		}
+	}
+
+	if len(entities) > n {
+		entities = entities[:n]
+	}
+	if len(entities) == 0 || o.KeysOnly {
+		return entities, nil
+	}
+
+	if err := loadInBatches(g, entities, loadEntities); err != nil {
+		filtered, err := ignoreErrors(ctx, entities, err, o)
+		if err != nil {
+			return nil, errors.Wrap(err, "error in GetMulti")
+		}
+		entities = filtered
+	}
+
+	return entities, nil
+}
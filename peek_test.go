@@ -0,0 +1,100 @@
+package generator
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mjibson/goon"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestPeek(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	orig := loadEntities
+	defer func() { loadEntities = orig }()
+	var calls, loaded int
+	loadEntities = func(g *goon.Goon, entities []interface{}) error {
+		calls++
+		loaded += len(entities)
+		return orig(g, entities)
+	}
+
+	entities, err := Peek(ctx, &Options{
+		Appender:  appender,
+		ParentKey: parentKey,
+		Query:     datastore.NewQuery("testHoge").Ancestor(parentKey),
+	}, 3)
+	if err != nil {
+		t.Fatalf("error in Peek: %+v", err)
+	}
+
+	if len(entities) != 3 {
+		t.Fatalf("number of entities differs => expected: 3, result: %d", len(entities))
+	}
+	for _, e := range entities {
+		if h := e.(*testHoge); h.Name == "" {
+			t.Fatalf("entity is not loaded: %+v", h)
+		}
+	}
+	if calls != 1 || loaded != 3 {
+		t.Fatalf("GetMulti differs => calls: %d, loaded: %d", calls, loaded)
+	}
+}
+
+func TestPeekWithoutAppender(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	q := datastore.NewQuery("testHoge").Ancestor(parentKey)
+	entities, err := Peek(ctx, &Options{
+		EntityType:             reflect.TypeOf(testHoge{}),
+		IgnoreErrFieldMismatch: true,
+		Query:                  q,
+	}, 3)
+	if err != nil {
+		t.Fatalf("error in Peek with EntityType: %+v", err)
+	}
+	if len(entities) != 3 {
+		t.Fatalf("number of entities differs => expected: 3, result: %d", len(entities))
+	}
+	for _, e := range entities {
+		if h := e.(*testHoge); h.Name == "" {
+			t.Fatalf("entity is not loaded: %+v", h)
+		}
+	}
+
+	keys, err := Peek(ctx, &Options{KeysOnly: true, Query: q}, 3)
+	if err != nil {
+		t.Fatalf("error in Peek with KeysOnly: %+v", err)
+	}
+	if len(keys) != 3 {
+		t.Fatalf("number of keys differs => expected: 3, result: %d", len(keys))
+	}
+	for _, k := range keys {
+		if _, ok := k.(*datastore.Key); !ok {
+			t.Fatalf("key is not yielded: %T", k)
+		}
+	}
+
+	if _, err := Peek(ctx, &Options{Query: q}, 3); err == nil {
+		t.Fatalf("error is not returned without Appender")
+	}
+}
@@ -0,0 +1,50 @@
+package generator
+
+import (
+	"google.golang.org/appengine/datastore"
+)
+
+// applyPredicate returns the entities in u that predicate accepts and their
+// keys.  It marks the chunk as filtered if none is left.
+func applyPredicate(u Unit, predicate func(e interface{}) bool) ([]interface{}, []*datastore.Key) {
+	if len(u.Entities) == 0 {
+		return u.Entities, u.Keys
+	}
+
+	accepted := make([]interface{}, 0, len(u.Entities))
+	var keys []*datastore.Key
+	for i, e := range u.Entities {
+		if predicate(e) {
+			accepted = append(accepted, e)
+			if i < len(u.Keys) {
+				keys = append(keys, u.Keys[i])
+			}
+		}
+	}
+
+	if len(accepted) == 0 && u.meta != nil {
+		u.meta.filtered = true
+	}
+
+	return accepted, keys
+}
+
+// dropFiltered yields Units from in except the chunks that Predicate has
+// emptied.  They are dropped here, after the stages that need every chunk
+// such as PreserveOrder and Manifest.
+func dropFiltered(in <-chan Unit) <-chan Unit {
+	out := make(chan Unit)
+
+	go func() {
+		defer close(out)
+
+		for u := range in {
+			if u.Err == nil && u.meta != nil && u.meta.filtered {
+				continue
+			}
+			out <- u
+		}
+	}()
+
+	return out
+}
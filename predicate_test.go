@@ -0,0 +1,55 @@
+package generator
+
+import (
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+)
+
+func TestPredicate(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	for _, preserveOrder := range []bool{false, true} {
+		count, units := 0, 0
+		for unit := range New(ctx, &Options{
+			Appender:               appender,
+			ChunkSize:              chunkSize,
+			IgnoreErrFieldMismatch: true,
+			ParentKey:              parentKey,
+			Predicate: func(e interface{}) bool {
+				return e.(*testHoge).Name == "Hoge Fugao"
+			},
+			PreserveOrder: preserveOrder,
+			Query:         datastore.NewQuery("testHoge").Ancestor(parentKey),
+		}) {
+			if unit.Err != nil {
+				t.Fatalf("error in unit: %+v", unit.Err)
+			}
+			if len(unit.Entities) == 0 {
+				t.Fatalf("empty chunk is yielded")
+			}
+			for _, e := range unit.Entities {
+				if h := e.(*testHoge); h.Name != "Hoge Fugao" {
+					t.Fatalf("entity is not dropped: %+v", h)
+				}
+				count++
+			}
+			units++
+		}
+
+		// Hoge Fugao are in the first 3 chunks.
+		expected := allHoges - allFugas
+		if count != expected || units != 3 {
+			t.Fatalf("number differs => preserveOrder: %v, expected: %d, result: %d, units: %d", preserveOrder, expected, count, units)
+		}
+	}
+}
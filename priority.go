@@ -0,0 +1,110 @@
+package generator
+
+import (
+	"container/heap"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+type prioritized struct {
+	entity   interface{}
+	key      *datastore.Key
+	kind     string
+	priority int
+	seq      int
+}
+
+// priorityQueue pops the highest priority first, and the earlier one for the
+// same priority.
+type priorityQueue []prioritized
+
+func (pq priorityQueue) Len() int { return len(pq) }
+
+func (pq priorityQueue) Less(i, j int) bool {
+	if pq[i].priority != pq[j].priority {
+		return pq[i].priority > pq[j].priority
+	}
+	return pq[i].seq < pq[j].seq
+}
+
+func (pq priorityQueue) Swap(i, j int) { pq[i], pq[j] = pq[j], pq[i] }
+
+func (pq *priorityQueue) Push(x interface{}) { *pq = append(*pq, x.(prioritized)) }
+
+func (pq *priorityQueue) Pop() interface{} {
+	old := *pq
+	n := len(old)
+	x := old[n-1]
+	*pq = old[:n-1]
+	return x
+}
+
+// prioritize buffers entities up to PriorityWindow and yields them in the
+// order of Priority.  Errors and the other Units without entities are yielded
+// after the buffered entities.
+func prioritize(ctx context.Context, in <-chan Unit, o *Options) <-chan Unit {
+	out := make(chan Unit)
+
+	window := o.PriorityWindow
+	if window <= 0 {
+		window = o.ChunkSize
+	}
+
+	go func() {
+		defer close(out)
+
+		pq := make(priorityQueue, 0, window)
+		seq := 0
+
+		flush := func() {
+			if len(pq) == 0 {
+				return
+			}
+			entities := make([]interface{}, 0, len(pq))
+			var keys []*datastore.Key
+			var kinds []string
+			if o.IncludeKind {
+				kinds = make([]string, 0, len(pq))
+			}
+			for len(pq) > 0 {
+				p := heap.Pop(&pq).(prioritized)
+				entities = append(entities, p.entity)
+				if p.key != nil {
+					keys = append(keys, p.key)
+				}
+				if o.IncludeKind {
+					kinds = append(kinds, p.kind)
+				}
+			}
+			out <- Unit{Entities: entities, Keys: keys, Kinds: kinds}
+		}
+
+		for u := range in {
+			if u.Err != nil || u.Kind != UnitData {
+				flush()
+				out <- u
+				continue
+			}
+
+			for i, e := range u.Entities {
+				p := prioritized{entity: e, priority: o.Priority(e), seq: seq}
+				if i < len(u.Keys) {
+					p.key = u.Keys[i]
+				}
+				if i < len(u.Kinds) {
+					p.kind = u.Kinds[i]
+				}
+				heap.Push(&pq, p)
+				seq++
+				if len(pq) >= window {
+					flush()
+				}
+			}
+		}
+
+		flush()
+	}()
+
+	return out
+}
@@ -0,0 +1,73 @@
+package generator
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+func TestPrioritize(t *testing.T) {
+	ctx := context.Background()
+	in := make(chan Unit)
+	out := prioritize(ctx, in, &Options{
+		Priority:       func(e interface{}) int { return e.(int) % 10 },
+		PriorityWindow: 7,
+	})
+
+	go func() {
+		defer close(in)
+		for i := 0; i < 4; i++ {
+			entities := make([]interface{}, 5)
+			for j := range entities {
+				entities[j] = i*5 + j
+			}
+			in <- Unit{Entities: entities}
+		}
+	}()
+
+	var sizes []int
+	for unit := range out {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		for i := 1; i < len(unit.Entities); i++ {
+			prev, cur := unit.Entities[i-1].(int), unit.Entities[i].(int)
+			if prev%10 < cur%10 || prev%10 == cur%10 && prev > cur {
+				t.Fatalf("priority is not kept: %v", unit.Entities)
+			}
+		}
+		sizes = append(sizes, len(unit.Entities))
+	}
+
+	if len(sizes) != 3 || sizes[0] != 7 || sizes[1] != 7 || sizes[2] != 6 {
+		t.Fatalf("sizes of windows differ: %v", sizes)
+	}
+}
+
+func TestPrioritizeWithError(t *testing.T) {
+	ctx := context.Background()
+	in := make(chan Unit)
+	out := prioritize(ctx, in, &Options{
+		ChunkSize: 10,
+		Priority:  func(e interface{}) int { return e.(int) },
+	})
+
+	someErr := errors.New("hoge error")
+	go func() {
+		defer close(in)
+		in <- Unit{Entities: []interface{}{1, 3, 2}}
+		in <- Unit{Err: someErr}
+	}()
+
+	u := <-out
+	if len(u.Entities) != 3 || u.Entities[0] != 3 || u.Entities[1] != 2 || u.Entities[2] != 1 {
+		t.Fatalf("buffered entities are not flushed before the error: %v", u.Entities)
+	}
+	if u := <-out; u.Err != someErr {
+		t.Fatalf("err differs: %+v", u.Err)
+	}
+	if _, ok := <-out; ok {
+		t.Fatalf("out has not been closed")
+	}
+}
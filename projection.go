@@ -0,0 +1,30 @@
+package generator
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// loadProjection loads props of a projection query into the last entity of
+// entities.  The entity is dropped if it has ErrFieldMismatch and
+// IgnoreErrFieldMismatch is set.
+func loadProjection(ctx context.Context, entities []interface{}, props datastore.PropertyList, o *Options) ([]interface{}, error) {
+	e := entities[len(entities)-1]
+
+	var err error
+	if pls, ok := e.(datastore.PropertyLoadSaver); ok {
+		err = pls.Load(props)
+	} else {
+		err = datastore.LoadStruct(e, props)
+	}
+	if _, ok := err.(*datastore.ErrFieldMismatch); ok && o.IgnoreErrFieldMismatch {
+		reportError(o, err)
+		logger(o).Warningf(ctx, "projection is ErrFieldMismatch, but ignore this: %v", err)
+		return entities[:len(entities)-1], nil
+	} else if err != nil {
+		return entities, errors.Wrap(err, "error in LoadStruct")
+	}
+
+	return entities, nil
+}
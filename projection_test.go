@@ -0,0 +1,69 @@
+package generator
+
+import (
+	"testing"
+
+	"github.com/mjibson/goon"
+	"github.com/pkg/errors"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestProject(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	orig := loadEntities
+	defer func() { loadEntities = orig }()
+	loadEntities = func(g *goon.Goon, entities []interface{}) error {
+		return errors.New("GetMulti is called")
+	}
+
+	names := map[string]int{}
+	for unit := range New(ctx, &Options{
+		Appender:  appender,
+		ChunkSize: chunkSize,
+		ParentKey: parentKey,
+		Project:   []string{"Name"},
+		Query:     datastore.NewQuery("testHoge").Ancestor(parentKey),
+	}) {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		for _, e := range unit.Entities {
+			h := e.(*testHoge)
+			if h.ID == 0 {
+				t.Fatalf("ID is not set: %+v", h)
+			}
+			names[h.Name]++
+		}
+	}
+
+	if names["Fuga Hogeo"] != allFugas || names["Hoge Fugao"] != allHoges-allFugas {
+		t.Fatalf("names differ: %v", names)
+	}
+}
+
+func TestProjectWithKeysOnly(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	unit := <-New(ctx, &Options{
+		KeysOnly: true,
+		Project:  []string{"Name"},
+		Query:    datastore.NewQuery("testHoge"),
+	})
+	if unit.Err == nil {
+		t.Fatalf("error is not yielded")
+	}
+}
@@ -0,0 +1,53 @@
+package generator
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// limiter spaces calls out by the interval.  It is shared by the goroutines
+// of getMulti.  A nil limiter does not wait.
+type limiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// newLimiter returns a limiter for qps calls per second.  It returns nil if
+// qps is not positive.
+func newLimiter(qps float64) *limiter {
+	if qps <= 0 {
+		return nil
+	}
+	return &limiter{interval: time.Duration(float64(time.Second) / qps)}
+}
+
+// wait waits for the turn of the call.  It returns false if ctx is done
+// before that.
+func (l *limiter) wait(ctx context.Context) bool {
+	if l == nil {
+		return true
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(l.interval)
+	l.mu.Unlock()
+
+	d := at.Sub(now)
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
@@ -0,0 +1,58 @@
+package generator
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/appengine/datastore"
+)
+
+func TestMaxQPS(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	// 6 chunks need 5 intervals of 50 milliseconds at least.
+	start := time.Now()
+	if err := testFetch(ctx, allHoges, &Options{
+		IgnoreErrFieldMismatch: true,
+		MaxQPS:                 20,
+		ParentKey:              parentKey,
+		Query:                  datastore.NewQuery("testHoge").Ancestor(parentKey),
+	}); err != nil {
+		t.Fatalf("error in testFetch: %+v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Fatalf("GetMulti is not throttled: %v", elapsed)
+	}
+}
+
+func TestLimiterWithCancelled(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+
+	l := newLimiter(0.1)
+	if !l.wait(ctx) {
+		t.Fatalf("first call waits")
+	}
+
+	cancel()
+	if l.wait(ctx) {
+		t.Fatalf("wait does not stop")
+	}
+
+	var none *limiter
+	if !none.wait(ctx) {
+		t.Fatalf("nil limiter waits")
+	}
+}
@@ -0,0 +1,68 @@
+package generator
+
+import (
+	"io"
+
+	"golang.org/x/net/context"
+)
+
+// readerPrefetch is the number of chunks that Reader fetches ahead.
+const readerPrefetch = 2
+
+// Reader reads entities from the generator by any number the caller wants.
+type Reader struct {
+	ch  <-chan Unit
+	buf []interface{}
+	err error
+}
+
+// NewReader returns a Reader to read entities yielded by New.  It fetches some
+// chunks ahead so that Read returns quickly.
+func NewReader(ctx context.Context, o *Options) *Reader {
+	ch := New(ctx, o)
+	prefetched := make(chan Unit, readerPrefetch)
+
+	go func() {
+		defer close(prefetched)
+
+		// this continues to receive after cancelled to drain ch.
+		for u := range ch {
+			select {
+			case <-ctx.Done():
+			case prefetched <- u:
+			}
+		}
+	}()
+
+	return &Reader{ch: prefetched}
+}
+
+// Read returns at most n entities.  It returns io.EOF when no entities remain.
+// When an error occurs, the entities read before that are returned first, and
+// the error is returned in the next call.
+func (r *Reader) Read(n int) ([]interface{}, error) {
+	for r.err == nil && len(r.buf) < n {
+		u, ok := <-r.ch
+		if !ok {
+			r.err = io.EOF
+			break
+		}
+		if u.Err != nil {
+			r.err = u.Err
+			break
+		}
+		r.buf = append(r.buf, u.Entities...)
+	}
+
+	if len(r.buf) == 0 {
+		return nil, r.err
+	}
+
+	if n > len(r.buf) {
+		n = len(r.buf)
+	}
+	entities := r.buf[:n:n]
+	r.buf = r.buf[n:]
+
+	return entities, nil
+}
@@ -0,0 +1,80 @@
+package generator
+
+import (
+	"io"
+	"sort"
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+)
+
+func TestReader(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	q := datastore.NewQuery("testHoge").Ancestor(parentKey)
+	r := NewReader(ctx, &Options{
+		Appender:               appender,
+		ChunkSize:              chunkSize,
+		IgnoreErrFieldMismatch: true,
+		ParentKey:              parentKey,
+		Query:                  q,
+	})
+
+	sizes := []int{1, 7, 3, 20, 0, 15, 9, 4}
+	var ids []int64
+	for i := 0; ; i++ {
+		n := sizes[i%len(sizes)]
+		entities, err := r.Read(n)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("error in Read: %+v", err)
+		}
+		if len(entities) > n {
+			t.Fatalf("too many entities => n: %d, result: %d", n, len(entities))
+		}
+		for _, e := range entities {
+			ids = append(ids, e.(*testHoge).ID)
+		}
+	}
+
+	if entities, err := r.Read(1); err != io.EOF || len(entities) != 0 {
+		t.Fatalf("Read after io.EOF returns entities: %v, err: %v", entities, err)
+	}
+
+	var expected []int64
+	for unit := range New(ctx, &Options{
+		Appender:               appender,
+		ChunkSize:              chunkSize,
+		IgnoreErrFieldMismatch: true,
+		ParentKey:              parentKey,
+		Query:                  q,
+	}) {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		for _, e := range unit.Entities {
+			expected = append(expected, e.(*testHoge).ID)
+		}
+	}
+
+	sort.Sort(int64s(ids))
+	sort.Sort(int64s(expected))
+	if len(ids) != allHoges || len(ids) != len(expected) {
+		t.Fatalf("number differs => expected: %d, result: %d", len(expected), len(ids))
+	}
+	for i := range ids {
+		if ids[i] != expected[i] {
+			t.Fatalf("ids differ at %d => expected: %d, result: %d", i, expected[i], ids[i])
+		}
+	}
+}
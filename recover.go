@@ -0,0 +1,16 @@
+package generator
+
+import (
+	"github.com/pkg/errors"
+)
+
+// protect calls fn, the callback named name in Options, and converts a panic
+// in it into an error so that the goroutine running it can close cleanly.
+func protect(name string, fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("panic in %s: %v", name, r)
+		}
+	}()
+	return fn()
+}
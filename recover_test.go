@@ -0,0 +1,65 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestProtect(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	q := datastore.NewQuery("testHoge").Ancestor(parentKey)
+	for _, c := range []struct {
+		name string
+		o    *Options
+	}{
+		{"Appender", &Options{
+			Appender: func(ctx context.Context, entities []interface{}, i int, k *datastore.Key, parentKey *datastore.Key) []interface{} {
+				return append(entities, &testHoge{ID: k.IntID(), Parent: parentKey.Parent()})
+			},
+			Query: q,
+		}},
+		{"Predicate", &Options{
+			Appender:  appender,
+			ParentKey: parentKey,
+			Predicate: func(e interface{}) bool { return e.(*testParent) != nil },
+			Query:     q,
+		}},
+		{"Transform", &Options{
+			Appender:  appender,
+			ParentKey: parentKey,
+			Query:     q,
+			Transform: func(e interface{}) (interface{}, error) {
+				var m map[string]interface{}
+				m["e"] = e
+				return m, nil
+			},
+		}},
+	} {
+		c.o.ChunkSize = chunkSize
+		c.o.IgnoreErrFieldMismatch = true
+
+		var found error
+		for unit := range New(ctx, c.o) {
+			if unit.Err != nil && found == nil {
+				found = unit.Err
+			}
+		}
+
+		if found == nil || !strings.Contains(found.Error(), "panic in "+c.name) {
+			t.Fatalf("panic in %s is not yielded: %v", c.name, found)
+		}
+	}
+}
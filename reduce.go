@@ -0,0 +1,52 @@
+package generator
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// GroupReduce runs the generator and folds entities with reduce while keyOf
+// returns the same key, and calls flush with the key and the state when the
+// key changes and at the end.  The state for a new group starts with nil.  It
+// assumes that entities in a group are yielded in a row, so
+// AlignToEntityGroups should be set when grouping by entity groups.  It stops
+// when flush returns an error, and returns that error or the first error in
+// the stream.
+func GroupReduce(ctx context.Context, o *Options, keyOf func(e interface{}) string, reduce func(state, e interface{}) interface{}, flush func(key string, state interface{}) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	ch := New(ctx, o)
+	defer func() {
+		cancel()
+		Drain(ch)
+	}()
+
+	var key string
+	var state interface{}
+	started := false
+	for unit := range ch {
+		if unit.Err != nil {
+			return errors.WithStack(unit.Err)
+		}
+
+		for _, e := range unit.Entities {
+			k := keyOf(e)
+			if started && k != key {
+				if err := flush(key, state); err != nil {
+					return errors.Wrap(err, "error in flush")
+				}
+				state = nil
+			}
+			key = k
+			state = reduce(state, e)
+			started = true
+		}
+	}
+
+	if started {
+		if err := flush(key, state); err != nil {
+			return errors.Wrap(err, "error in flush")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package generator
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/mjibson/goon"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestGroupReduce(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	g := goon.FromContext(ctx)
+	sizes := []int{4, 3, 7, 1, 5}
+	for i, size := range sizes {
+		parentKey, err := g.Put(&testParent{ID: int64(i + 1)})
+		if err != nil {
+			t.Fatalf("error in Put: %+v", err)
+		}
+		h := make([]*testHoge, size)
+		for j := range h {
+			h[j] = &testHoge{Parent: parentKey, Name: "Hoge Fugao"}
+		}
+		if _, err := g.PutMulti(h); err != nil {
+			t.Fatalf("error in PutMulti: %+v", err)
+		}
+	}
+
+	flushed := map[string]int{}
+	if err := GroupReduce(ctx, &Options{
+		AlignToEntityGroups: true,
+		Appender: func(ctx context.Context, entities []interface{}, i int, k *datastore.Key, parentKey *datastore.Key) []interface{} {
+			return append(entities, &testHoge{ID: k.IntID(), Parent: k.Parent()})
+		},
+		ChunkSize: 5,
+		Query:     datastore.NewQuery("testHoge"),
+	}, func(e interface{}) string {
+		return fmt.Sprint(e.(*testHoge).Parent.IntID())
+	}, func(state, e interface{}) interface{} {
+		if state == nil {
+			return 1
+		}
+		return state.(int) + 1
+	}, func(key string, state interface{}) error {
+		if _, ok := flushed[key]; ok {
+			return fmt.Errorf("group %s is flushed twice", key)
+		}
+		flushed[key] = state.(int)
+		return nil
+	}); err != nil {
+		t.Fatalf("error in GroupReduce: %+v", err)
+	}
+
+	if len(flushed) != len(sizes) {
+		t.Fatalf("number of groups differs => expected: %d, result: %d", len(sizes), len(flushed))
+	}
+	for i, size := range sizes {
+		if c := flushed[fmt.Sprint(i+1)]; c != size {
+			t.Fatalf("state for group %d differs => expected: %d, result: %d", i+1, size, c)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package generator
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// KindRegistry makes entities for each kind.  Its Appender can be used for a
+// query over many kinds, instead of an Appender with a large type switch.
+type KindRegistry struct {
+	// Logger logs unregistered kinds.  The default is the log of App Engine.
+	Logger Logger
+
+	kinds map[string]kindEntry
+}
+
+type kindEntry struct {
+	factory func() interface{}
+	setKey  func(e interface{}, k *datastore.Key)
+}
+
+// NewKindRegistry returns an empty KindRegistry.
+func NewKindRegistry() *KindRegistry {
+	return &KindRegistry{kinds: make(map[string]kindEntry)}
+}
+
+// Register sets the functions for kind.  factory returns a pointer to a new
+// entity, and setKey sets the fields for the key, such as the ID and the
+// parent for goon.
+func (r *KindRegistry) Register(kind string, factory func() interface{}, setKey func(e interface{}, k *datastore.Key)) {
+	r.kinds[kind] = kindEntry{factory: factory, setKey: setKey}
+}
+
+// Appender returns an Appender that makes an entity by the kind of the key.
+// Keys of unregistered kinds are skipped with a warning.
+func (r *KindRegistry) Appender() Appender {
+	return func(ctx context.Context, entities []interface{}, i int, k *datastore.Key, parentKey *datastore.Key) []interface{} {
+		entry, ok := r.kinds[k.Kind()]
+		if !ok {
+			r.logger().Warningf(ctx, "kind is not registered: %v", k)
+			return entities
+		}
+
+		e := entry.factory()
+		entry.setKey(e, k)
+		return append(entities, e)
+	}
+}
+
+func (r *KindRegistry) logger() Logger {
+	if r.Logger == nil {
+		return appengineLogger{}
+	}
+	return r.Logger
+}
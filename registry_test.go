@@ -0,0 +1,60 @@
+package generator
+
+import (
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+)
+
+func TestKindRegistry(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	r := NewKindRegistry()
+	r.Register("testHoge", func() interface{} { return &testHoge{} }, func(e interface{}, k *datastore.Key) {
+		h := e.(*testHoge)
+		h.ID = k.IntID()
+		h.Parent = k.Parent()
+	})
+	r.Register("testParent", func() interface{} { return &testParent{} }, func(e interface{}, k *datastore.Key) {
+		e.(*testParent).ID = k.IntID()
+	})
+
+	// the kindless query yields the parent and its children.
+	var parents, hoges int
+	for unit := range New(ctx, &Options{
+		Appender:               r.Appender(),
+		ChunkSize:              chunkSize,
+		IgnoreErrFieldMismatch: true,
+		Query:                  datastore.NewQuery("").Ancestor(parentKey),
+	}) {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		for _, e := range unit.Entities {
+			switch v := e.(type) {
+			case *testParent:
+				parents++
+			case *testHoge:
+				if v.Name == "" {
+					t.Fatalf("entity is not loaded: %+v", v)
+				}
+				hoges++
+			default:
+				t.Fatalf("unexpected type: %T", e)
+			}
+		}
+	}
+
+	if parents != 1 || hoges != allHoges {
+		t.Fatalf("number differs => parents: %d, hoges: %d", parents, hoges)
+	}
+}
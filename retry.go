@@ -0,0 +1,54 @@
+package generator
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+const defaultRetryBackoff = 100 * time.Millisecond
+
+// isRetryable is the default IsRetryable.  It retries timeouts, conflicts of
+// transactions and ErrChaos.
+func isRetryable(err error) bool {
+	err = errors.Cause(err)
+	return err == datastore.ErrConcurrentTransaction || err == context.DeadlineExceeded || err == ErrChaos || appengine.IsTimeoutError(err)
+}
+
+// retry waits for the backoff and returns true if err should be retried on the
+// attempt, which starts with 0.  MultiError is not retried because it has
+// errors for each entity such as ErrFieldMismatch.
+func retry(ctx context.Context, o *Options, attempt int, err error) bool {
+	if attempt >= o.MaxRetries {
+		return false
+	}
+	if _, ok := errors.Cause(err).(appengine.MultiError); ok {
+		return false
+	}
+
+	retryable := o.IsRetryable
+	if retryable == nil {
+		retryable = isRetryable
+	}
+	if !retryable(err) {
+		return false
+	}
+
+	backoff := o.RetryBackoff
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+	backoff <<= uint(attempt)
+
+	reportError(o, err)
+	logger(o).Warningf(ctx, "retry after %v: %v", backoff, err)
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(backoff):
+		return true
+	}
+}
@@ -0,0 +1,163 @@
+package generator
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/mjibson/goon"
+	"github.com/pkg/errors"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+type failingIterator struct {
+	iterator
+	err error
+}
+
+func (t *failingIterator) Next(dst interface{}) (*datastore.Key, error) {
+	return nil, t.err
+}
+
+func TestRetry(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	// the second and the third runs fail, and so does the first GetMulti.
+	origRunQuery := runQuery
+	defer func() { runQuery = origRunQuery }()
+	runs := 0
+	runQuery = func(g *goon.Goon, q *datastore.Query) iterator {
+		runs++
+		t := origRunQuery(g, q)
+		if runs == 2 || runs == 3 {
+			return &failingIterator{t, datastore.ErrConcurrentTransaction}
+		}
+		return t
+	}
+
+	origLoadEntities := loadEntities
+	defer func() { loadEntities = origLoadEntities }()
+	loads := 0
+	loadEntities = func(g *goon.Goon, entities []interface{}) error {
+		loads++
+		if loads == 1 {
+			return errors.New("transient error")
+		}
+		return origLoadEntities(g, entities)
+	}
+
+	count := 0
+	for unit := range New(ctx, &Options{
+		Appender:  appender,
+		ChunkSize: allFugas,
+		IsRetryable: func(err error) bool {
+			return isRetryable(err) || err.Error() == "transient error"
+		},
+		MaxRetries:   2,
+		ParentKey:    parentKey,
+		Query:        datastore.NewQuery("testHoge").Ancestor(parentKey).Filter("Name =", "Fuga Hogeo"),
+		RetryBackoff: 1,
+	}) {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		count += len(unit.Entities)
+	}
+
+	if count != allFugas {
+		t.Fatalf("number differs => expected: %d, result: %d", allFugas, count)
+	}
+	if runs != 4 || loads != 2 {
+		t.Fatalf("number of calls differs => runs: %d, loads: %d", runs, loads)
+	}
+}
+
+func TestRetryWithMultiError(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	orig := loadEntities
+	defer func() { loadEntities = orig }()
+	loads := 0
+	loadEntities = func(g *goon.Goon, entities []interface{}) error {
+		loads++
+		return appengine.MultiError{&datastore.ErrFieldMismatch{FieldName: "OldName"}}
+	}
+
+	in := make(chan Unit)
+	out := getMulti(ctx, nil, in, &Options{
+		IsRetryable: func(err error) bool { return true },
+		MaxRetries:  3,
+	}, func() {})
+	in <- Unit{Entities: []interface{}{&testHoge{ID: 1}}}
+	close(in)
+
+	u := <-out
+	if u.Err == nil {
+		t.Fatalf("no error in unit")
+	}
+	if loads != 1 {
+		t.Fatalf("MultiError is retried: %d", loads)
+	}
+}
+
+func TestAttempts(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	// the first GetMulti of the chunk with ID 1 fails once.
+	orig := loadEntities
+	defer func() { loadEntities = orig }()
+	var mu sync.Mutex
+	failed := false
+	loadEntities = func(g *goon.Goon, entities []interface{}) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if entities[0].(*testHoge).ID == 1 && !failed {
+			failed = true
+			return errors.New("transient error")
+		}
+		return nil
+	}
+
+	in := make(chan Unit)
+	out := getMulti(ctx, nil, in, &Options{
+		IsRetryable:  func(err error) bool { return true },
+		MaxRetries:   1,
+		RetryBackoff: 1,
+	}, func() {})
+	go func() {
+		defer close(in)
+		for i := int64(1); i <= 3; i++ {
+			in <- Unit{Entities: []interface{}{&testHoge{ID: i}}}
+		}
+	}()
+
+	attempts := map[int64]int{}
+	for u := range out {
+		if u.Err != nil {
+			t.Fatalf("error in unit: %+v", u.Err)
+		}
+		attempts[u.Entities[0].(*testHoge).ID] = u.Attempts
+	}
+
+	if expected := map[int64]int{1: 2, 2: 1, 3: 1}; !reflect.DeepEqual(attempts, expected) {
+		t.Fatalf("attempts differ => expected: %v, result: %v", expected, attempts)
+	}
+}
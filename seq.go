@@ -0,0 +1,66 @@
+//go:build go1.23
+// +build go1.23
+
+package generator
+
+import (
+	"iter"
+
+	"golang.org/x/net/context"
+)
+
+// Seq returns an iterator that yields each entity from the generator.  When an
+// error occurs, it yields the error with a nil entity and stops.  If the loop
+// breaks, the generator is cancelled and its goroutines finish before the loop
+// exits.
+//
+//	for e, err := range generator.Seq(ctx, o) {
+//	  if err != nil {
+//	    return err
+//	  }
+//	  // some nice handling
+//	}
+func Seq(ctx context.Context, o *Options) iter.Seq2[interface{}, error] {
+	return func(yield func(interface{}, error) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		ch := New(ctx, o)
+		defer func() {
+			cancel()
+			Drain(ch)
+		}()
+
+		for unit := range ch {
+			if unit.Err != nil {
+				yield(nil, unit.Err)
+				return
+			}
+			for _, e := range unit.Entities {
+				if !yield(e, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// SeqOf returns an iterator that yields each entity from ch, such as the
+// channel from New.  Errors are yielded as Seq does.  If the loop breaks, ch
+// is drained before the loop exits so that the generator can finish.  Cancel
+// the context given to New to stop the generator sooner, or use Seq.
+func SeqOf(ch <-chan Unit) iter.Seq2[interface{}, error] {
+	return func(yield func(interface{}, error) bool) {
+		defer Drain(ch)
+
+		for unit := range ch {
+			if unit.Err != nil {
+				yield(nil, unit.Err)
+				return
+			}
+			for _, e := range unit.Entities {
+				if !yield(e, nil) {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,167 @@
+//go:build go1.23
+// +build go1.23
+
+package generator
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestSeq(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	count := 0
+	for e, err := range Seq(ctx, &Options{
+		Appender:               appender,
+		ChunkSize:              chunkSize,
+		IgnoreErrFieldMismatch: true,
+		ParentKey:              parentKey,
+		Query:                  datastore.NewQuery("testHoge").Ancestor(parentKey),
+	}) {
+		if err != nil {
+			t.Fatalf("error in Seq: %+v", err)
+		}
+		if _, ok := e.(*testHoge); !ok {
+			t.Fatalf("e is not *testHoge: %+v", e)
+		}
+		count++
+	}
+
+	if count != allHoges {
+		t.Fatalf("number differs => expected: %d, result: %d", allHoges, count)
+	}
+}
+
+func TestSeqWithBreak(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	before := runtime.NumGoroutine()
+
+	count := 0
+	for _, err := range Seq(ctx, &Options{
+		Appender:               appender,
+		ChunkSize:              chunkSize,
+		IgnoreErrFieldMismatch: true,
+		ParentKey:              parentKey,
+		Query:                  datastore.NewQuery("testHoge").Ancestor(parentKey),
+	}) {
+		if err != nil {
+			t.Fatalf("error in Seq: %+v", err)
+		}
+		count++
+		if count == 5 {
+			break
+		}
+	}
+
+	if count != 5 {
+		t.Fatalf("number differs => expected: 5, result: %d", count)
+	}
+
+	for i := 0; runtime.NumGoroutine() > before; i++ {
+		if i == 100 {
+			t.Fatalf("goroutines remain => before: %d, after: %d", before, runtime.NumGoroutine())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSeqWithError(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	errs := 0
+	for e, err := range Seq(ctx, &Options{
+		Appender:  appender,
+		ChunkSize: chunkSize,
+		ParentKey: parentKey,
+		Query:     datastore.NewQuery("testHoge").Ancestor(parentKey),
+	}) {
+		if err != nil {
+			if e != nil {
+				t.Fatalf("entity is yielded with the error: %+v", e)
+			}
+			errs++
+		}
+	}
+
+	if errs != 1 {
+		t.Fatalf("number of errors differs => expected: 1, result: %d", errs)
+	}
+}
+
+func TestSeqOf(t *testing.T) {
+	ch := make(chan Unit, 3)
+	ch <- Unit{Entities: []interface{}{1, 2}}
+	ch <- Unit{Entities: []interface{}{3}}
+	ch <- Unit{Entities: []interface{}{4}}
+	close(ch)
+
+	var result []interface{}
+	for e, err := range SeqOf(ch) {
+		if err != nil {
+			t.Fatalf("error in SeqOf: %+v", err)
+		}
+		result = append(result, e)
+		if len(result) == 3 {
+			break
+		}
+	}
+
+	if len(result) != 3 || result[2] != 3 {
+		t.Fatalf("entities differ: %v", result)
+	}
+	if _, ok := <-ch; ok {
+		t.Fatalf("ch has not been drained")
+	}
+}
+
+func TestSeqOfWithError(t *testing.T) {
+	ch := make(chan Unit, 2)
+	ch <- Unit{Err: errors.New("some error"), Kind: UnitError}
+	ch <- Unit{Entities: []interface{}{1}}
+	close(ch)
+
+	count := 0
+	for e, err := range SeqOf(ch) {
+		count++
+		if err == nil || e != nil {
+			t.Fatalf("error is not yielded: %v, %v", e, err)
+		}
+	}
+
+	if count != 1 {
+		t.Fatalf("iteration does not stop: %d", count)
+	}
+}
@@ -0,0 +1,51 @@
+package generator
+
+// sequence yields Units in the order of chunks in the query and sets Seq.  It
+// is used for both PreserveOrder and Sequence.  Units that arrive early are
+// buffered until the preceding ones come.
+func sequence(in <-chan Unit) <-chan Unit {
+	out := make(chan Unit)
+
+	go func() {
+		defer close(out)
+
+		pending := make(map[int]Unit)
+		next := 0
+		seq := 0
+		emit := func(u Unit) {
+			if u.Err == nil {
+				u.Seq = seq
+				seq += len(u.Entities)
+			}
+			out <- u
+		}
+
+		for u := range in {
+			if u.meta == nil {
+				out <- u
+				continue
+			}
+
+			pending[u.meta.index] = u
+			for {
+				p, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				next++
+				emit(p)
+			}
+		}
+
+		// some chunks may be lost if the context is cancelled.
+		for ; len(pending) > 0; next++ {
+			if p, ok := pending[next]; ok {
+				delete(pending, next)
+				emit(p)
+			}
+		}
+	}()
+
+	return out
+}
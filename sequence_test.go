@@ -0,0 +1,60 @@
+package generator
+
+import (
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+)
+
+func TestSequence(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	o := &Options{
+		Appender:  appender,
+		ChunkSize: chunkSize,
+		ParentKey: parentKey,
+		Query:     datastore.NewQuery("testHoge").Ancestor(parentKey).Filter("Name =", "Fuga Hogeo"),
+		Sequence:  true,
+	}
+
+	var ids []int64
+	for _, rerun := range []bool{false, true} {
+		seq := 0
+		i := 0
+		for unit := range New(ctx, o) {
+			if unit.Err != nil {
+				t.Fatalf("error in unit: %+v", unit.Err)
+			}
+			if unit.Seq != seq {
+				t.Fatalf("Seq differs => expected: %d, result: %d", seq, unit.Seq)
+			}
+			seq += len(unit.Entities)
+
+			for _, e := range unit.Entities {
+				id := e.(*testHoge).ID
+				if !rerun {
+					if len(ids) > 0 && id <= ids[len(ids)-1] {
+						t.Fatalf("entities are not in the query order: %d after %d", id, ids[len(ids)-1])
+					}
+					ids = append(ids, id)
+				} else if id != ids[i] {
+					t.Fatalf("ID for %d differs in the second run => expected: %d, result: %d", i, ids[i], id)
+				}
+				i++
+			}
+		}
+
+		if seq != allFugas {
+			t.Fatalf("number differs => expected: %d, result: %d", allFugas, seq)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package generator
+
+import (
+	"sort"
+
+	"github.com/mjibson/goon"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// shardOversampling is the number of sampled keys for each shard.  More
+// samples make the shards more even.
+const shardOversampling = 32
+
+// NewSharded splits the query into shards by ranges of keys, and runs them in
+// parallel.  The Units of the shards are merged as Merge does, so they are not
+// in the query order.  The ranges are made from keys sampled by __scatter__,
+// and applied as filters of __key__, so the query cannot have inequality
+// filters or sort orders.  An error of a shard is yielded, but does not stop
+// the others.  StartCursor, Offset, Limit, Tail and Checkpoint cannot be used
+// because they are for a single cursor, nor the options that Merge rejects.
+// With Dedup, the shards share one SeenSet as Merge does.  The SeenSet of o is
+// used under a lock, so it does not have to be safe for concurrent use.
+func NewSharded(ctx context.Context, o *Options, shards int) <-chan Unit {
+	if shards <= 1 || o == nil || o.Query == nil {
+		return New(ctx, o)
+	}
+	if o.StartCursor != "" || o.Offset > 0 || o.Limit > 0 || o.Tail || o.Checkpoint != nil {
+		return errorChannel(errors.New("StartCursor, Offset, Limit, Tail and Checkpoint cannot be used with NewSharded"))
+	}
+	if err := mergeConflict(o); err != nil {
+		return errorChannel(err)
+	}
+
+	nctx, err := withNamespace(ctx, o)
+	if err != nil {
+		return errorChannel(err)
+	}
+
+	ss, err := makeShards(goon.FromContext(nctx), o.Query, shards)
+	if err != nil {
+		return errorChannel(err)
+	}
+
+	var os []*Options
+	for _, s := range ss {
+		oc := *o
+		oc.Query = s.Query(o.Query)
+		os = append(os, &oc)
+	}
+
+	return Merge(ctx, os)
+}
+
+// Shard is one of the disjoint ranges of keys that NewSharded runs in
+// parallel.  Start and End are nil at the ends of the key space.
+type Shard struct {
+	Index, Count int
+	Start, End   *datastore.Key
+}
+
+// Query returns q restricted to the range of keys of the shard.
+func (s Shard) Query(q *datastore.Query) *datastore.Query {
+	if s.Start != nil {
+		q = q.Filter("__key__ >=", s.Start)
+	}
+	if s.End != nil {
+		q = q.Filter("__key__ <", s.End)
+	}
+	return q
+}
+
+// makeShards splits q into at most n shards by the keys sampled with g.
+func makeShards(g *goon.Goon, q *datastore.Query, n int) ([]Shard, error) {
+	samples, err := sampleKeys(g, q, n*shardOversampling)
+	if err != nil {
+		return nil, errors.Wrap(err, "error in sampleKeys")
+	}
+
+	splits := splitKeys(samples, n)
+	ss := make([]Shard, 0, len(splits)+1)
+	for i := 0; i <= len(splits); i++ {
+		s := Shard{Index: i, Count: len(splits) + 1}
+		if i > 0 {
+			s.Start = splits[i-1]
+		}
+		if i < len(splits) {
+			s.End = splits[i]
+		}
+		ss = append(ss, s)
+	}
+	return ss, nil
+}
+
+// sampleKeys returns at most n keys of q at random.  This can be replaced in
+// tests.
+var sampleKeys = func(g *goon.Goon, q *datastore.Query, n int) ([]*datastore.Key, error) {
+	return q.KeysOnly().Order("__scatter__").Limit(n).GetAll(g.Context, nil)
+}
+
+// byKey sorts keys in the order of the datastore.
+type byKey []*datastore.Key
+
+func (k byKey) Len() int           { return len(k) }
+func (k byKey) Less(i, j int) bool { return compareKeys(k[i], k[j]) < 0 }
+func (k byKey) Swap(i, j int)      { k[i], k[j] = k[j], k[i] }
+
+// splitKeys chooses the keys to split samples into shards evenly.  Keys that
+// are the same are chosen once.
+func splitKeys(samples []*datastore.Key, shards int) []*datastore.Key {
+	sort.Sort(byKey(samples))
+
+	var splits []*datastore.Key
+	for i := 1; i < shards; i++ {
+		j := i * len(samples) / shards
+		if j >= len(samples) {
+			break
+		}
+		k := samples[j]
+		if len(splits) > 0 && splits[len(splits)-1].Equal(k) {
+			continue
+		}
+		splits = append(splits, k)
+	}
+
+	return splits
+}
+
+// compareKeys compares keys in the order of the datastore.  Ancestors come
+// first, and in each element of the path, int IDs come before string IDs.
+func compareKeys(a, b *datastore.Key) int {
+	pa, pb := keyPath(a), keyPath(b)
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		x, y := pa[i], pb[i]
+		if x.Kind() != y.Kind() {
+			if x.Kind() < y.Kind() {
+				return -1
+			}
+			return 1
+		}
+		xs, ys := x.StringID() != "", y.StringID() != ""
+		switch {
+		case xs != ys:
+			if ys {
+				return -1
+			}
+			return 1
+		case xs && x.StringID() != y.StringID():
+			if x.StringID() < y.StringID() {
+				return -1
+			}
+			return 1
+		case !xs && x.IntID() != y.IntID():
+			if x.IntID() < y.IntID() {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(pa) - len(pb)
+}
+
+// keyPath returns the keys from the root ancestor to k.
+func keyPath(k *datastore.Key) []*datastore.Key {
+	var path []*datastore.Key
+	for ; k != nil; k = k.Parent() {
+		path = append([]*datastore.Key{k}, path...)
+	}
+	return path
+}
@@ -0,0 +1,159 @@
+package generator
+
+import (
+	"testing"
+
+	"github.com/mjibson/goon"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestNewSharded(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	// the test datastore does not have __scatter__, so all keys are samples.
+	orig := sampleKeys
+	defer func() { sampleKeys = orig }()
+	var sampled []*datastore.Key
+	sampleKeys = func(g *goon.Goon, q *datastore.Query, n int) ([]*datastore.Key, error) {
+		keys, err := q.KeysOnly().GetAll(g.Context, nil)
+		sampled = keys
+		return keys, err
+	}
+
+	ids := map[int64]int{}
+	for unit := range NewSharded(ctx, &Options{
+		Appender:               appender,
+		ChunkSize:              chunkSize,
+		IgnoreErrFieldMismatch: true,
+		ParentKey:              parentKey,
+		Query:                  datastore.NewQuery("testHoge").Ancestor(parentKey),
+	}, 4) {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		for _, e := range unit.Entities {
+			ids[e.(*testHoge).ID]++
+		}
+	}
+
+	if len(ids) != allHoges {
+		t.Fatalf("number differs => expected: %d, result: %d", allHoges, len(ids))
+	}
+	for id, n := range ids {
+		if n != 1 {
+			t.Fatalf("entity %d is yielded %d times", id, n)
+		}
+	}
+	if splits := splitKeys(sampled, 4); len(splits) != 3 {
+		t.Fatalf("number of splits differs => expected: 3, result: %d", len(splits))
+	}
+
+	unit := <-NewSharded(ctx, &Options{
+		Appender: appender,
+		Limit:    10,
+		Query:    datastore.NewQuery("testHoge"),
+	}, 4)
+	if unit.Err == nil {
+		t.Fatalf("error is not yielded for Limit")
+	}
+
+	unit = <-NewSharded(ctx, &Options{
+		Appender: appender,
+		Query:    datastore.NewQuery("testHoge"),
+		Summary:  true,
+	}, 4)
+	if unit.Err == nil {
+		t.Fatalf("error is not yielded for Summary")
+	}
+}
+
+// mapSet is a SeenSet that is not safe for concurrent use.
+type mapSet map[string]bool
+
+func (s mapSet) Add(key string)           { s[key] = true }
+func (s mapSet) Contains(key string) bool { return s[key] }
+
+func TestNewShardedWithSeenSet(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	orig := sampleKeys
+	defer func() { sampleKeys = orig }()
+	sampleKeys = func(g *goon.Goon, q *datastore.Query, n int) ([]*datastore.Key, error) {
+		return q.KeysOnly().GetAll(g.Context, nil)
+	}
+
+	seen := mapSet{}
+	keys := map[string]int{}
+	for unit := range NewSharded(ctx, &Options{
+		ChunkSize: chunkSize,
+		Dedup:     true,
+		KeysOnly:  true,
+		ParentKey: parentKey,
+		Query:     datastore.NewQuery("testHoge").Ancestor(parentKey),
+		SeenSet:   seen,
+	}, 4) {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		for _, e := range unit.Entities {
+			keys[e.(*datastore.Key).Encode()]++
+		}
+	}
+
+	if len(keys) != allHoges+1 {
+		t.Fatalf("number of keys differs => expected: %d, result: %d", allHoges+1, len(keys))
+	}
+	for k, n := range keys {
+		if n != 1 {
+			t.Fatalf("key is yielded %d times: %s", n, k)
+		}
+		if !seen[k] {
+			t.Fatalf("key is not added to SeenSet: %s", k)
+		}
+	}
+}
+
+func TestCompareKeys(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parent := datastore.NewKey(ctx, "A", "", 2, nil)
+	keys := []*datastore.Key{
+		datastore.NewKey(ctx, "A", "", 1, nil),
+		parent,
+		datastore.NewKey(ctx, "B", "", 1, parent),
+		datastore.NewKey(ctx, "B", "a", 0, parent),
+		datastore.NewKey(ctx, "A", "", 10, nil),
+		datastore.NewKey(ctx, "A", "a", 0, nil),
+		datastore.NewKey(ctx, "B", "", 1, nil),
+	}
+	for i := range keys {
+		for j := range keys {
+			c := compareKeys(keys[i], keys[j])
+			if i < j && c >= 0 || i == j && c != 0 || i > j && c <= 0 {
+				t.Fatalf("order differs => %v, %v: %d", keys[i], keys[j], c)
+			}
+		}
+	}
+}
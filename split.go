@@ -0,0 +1,64 @@
+package generator
+
+import (
+	"strings"
+
+	"github.com/mjibson/goon"
+	"google.golang.org/appengine"
+)
+
+// isTooLarge reports whether err is the error of the datastore for the
+// request or the response over the size limit.  This can be replaced in
+// tests.
+var isTooLarge = func(err error) bool {
+	if _, ok := err.(appengine.MultiError); ok {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "too large") || strings.Contains(msg, "exceeds the maximum")
+}
+
+// splitOnTooLarge returns load that splits entities in half and loads each
+// half again when load fails over the size limit.  The error is returned only
+// when a single entity fails so.
+func splitOnTooLarge(load func(g *goon.Goon, entities []interface{}) error) func(g *goon.Goon, entities []interface{}) error {
+	var split func(g *goon.Goon, entities []interface{}) error
+	split = func(g *goon.Goon, entities []interface{}) error {
+		err := load(g, entities)
+		if err == nil || len(entities) <= 1 || !isTooLarge(err) {
+			return err
+		}
+
+		half := len(entities) / 2
+		return mergeErrors(entities, half, split(g, entities[:half]), split(g, entities[half:]))
+	}
+	return split
+}
+
+// mergeErrors merges the errors of entities[:half] and entities[half:] into
+// one for entities.  An error other than MultiError is returned as it is.
+func mergeErrors(entities []interface{}, half int, head, tail error) error {
+	if head == nil && tail == nil {
+		return nil
+	}
+
+	mErr := make(appengine.MultiError, len(entities))
+	for _, h := range []struct {
+		err        error
+		start, end int
+	}{{head, 0, half}, {tail, half, len(entities)}} {
+		if h.err == nil {
+			continue
+		}
+		hErr, ok := h.err.(appengine.MultiError)
+		if !ok {
+			return h.err
+		}
+		if err := checkAlignment(entities[h.start:h.end], hErr); err != nil {
+			return err
+		}
+		copy(mErr[h.start:h.end], hErr)
+	}
+
+	return mErr
+}
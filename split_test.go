@@ -0,0 +1,97 @@
+package generator
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/mjibson/goon"
+	"github.com/pkg/errors"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestSplitOnTooLarge(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	// more than 3 entities are too large, and the entity of ID 5 has
+	// ErrFieldMismatch.
+	orig := loadEntities
+	defer func() { loadEntities = orig }()
+	var mu sync.Mutex
+	calls := 0
+	loadEntities = func(g *goon.Goon, entities []interface{}) error {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		if len(entities) > 3 {
+			return errors.New("API error 1 (datastore_v3: BAD_REQUEST): response too large")
+		}
+		var mErr appengine.MultiError
+		for i, e := range entities {
+			if e.(*testHoge).ID == 5 {
+				mErr = make(appengine.MultiError, len(entities))
+				mErr[i] = &datastore.ErrFieldMismatch{FieldName: "OldName"}
+			}
+		}
+		if mErr != nil {
+			return mErr
+		}
+		return nil
+	}
+
+	const size = 10
+	entities := make([]interface{}, size)
+	for i := range entities {
+		entities[i] = &testHoge{ID: int64(i + 1)}
+	}
+
+	in := make(chan Unit)
+	out := getMulti(ctx, nil, in, &Options{IgnoreErrFieldMismatch: true}, func() {})
+	in <- Unit{Entities: entities}
+	close(in)
+	u := <-out
+
+	if u.Err != nil {
+		t.Fatalf("error in unit: %+v", u.Err)
+	}
+	// 10 -> 5, 5 -> 2, 3, 2, 3
+	if calls != 7 {
+		t.Fatalf("number of calls differs => expected: 7, result: %d", calls)
+	}
+	if len(u.Entities) != size-1 {
+		t.Fatalf("number differs => expected: %d, result: %d", size-1, len(u.Entities))
+	}
+	for _, e := range u.Entities {
+		if e.(*testHoge).ID == 5 {
+			t.Fatalf("entity with ErrFieldMismatch is not filtered")
+		}
+	}
+}
+
+func TestSplitOnTooLargeForSingleEntity(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	orig := loadEntities
+	defer func() { loadEntities = orig }()
+	loadEntities = func(g *goon.Goon, entities []interface{}) error {
+		return errors.New("API error 1 (datastore_v3: BAD_REQUEST): response too large")
+	}
+
+	in := make(chan Unit)
+	out := getMulti(ctx, nil, in, &Options{}, func() {})
+	in <- Unit{Entities: []interface{}{&testHoge{ID: 1}, &testHoge{ID: 2}}}
+	close(in)
+	u := <-out
+
+	if u.Err == nil || !isTooLarge(errors.Cause(u.Err)) {
+		t.Fatalf("error over the size limit is not yielded: %v", u.Err)
+	}
+}
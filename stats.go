@@ -0,0 +1,58 @@
+package generator
+
+import (
+	"sync"
+	"time"
+)
+
+// Stats has the numbers of a run.  It is updated while the run goes, and the
+// numbers are final after the channel is closed.  It accumulates over runs
+// if it is shared by them.
+type Stats struct {
+	// ChunksProduced is the number of chunks the query has made.
+	ChunksProduced int
+	// EntitiesFetched is the number of entities loaded by GetMulti, except
+	// the ignored ones.
+	EntitiesFetched int
+	// GetMultiCalls is the number of calls of GetMulti, including retries.
+	GetMultiCalls int
+	// TotalGetMultiDuration is the sum of the durations of GetMulti.  It can
+	// be longer than the run because GetMulti runs concurrently.
+	TotalGetMultiDuration time.Duration
+	// QueryDuration is the time to run the query and make the chunks.
+	QueryDuration time.Duration
+
+	mu sync.Mutex
+}
+
+// addChunk counts a chunk made in d.  s can be nil.
+func (s *Stats) addChunk(d time.Duration) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ChunksProduced++
+	s.QueryDuration += d
+}
+
+// addGetMulti counts a call of GetMulti that took d.  s can be nil.
+func (s *Stats) addGetMulti(d time.Duration) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.GetMultiCalls++
+	s.TotalGetMultiDuration += d
+}
+
+// addFetched counts n entities loaded.  s can be nil.
+func (s *Stats) addFetched(n int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.EntitiesFetched += n
+}
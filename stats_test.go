@@ -0,0 +1,50 @@
+package generator
+
+import (
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+)
+
+func TestStats(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	var stats Stats
+	chunks := 0
+	for unit := range New(ctx, &Options{
+		Appender:               appender,
+		ChunkSize:              chunkSize,
+		IgnoreErrFieldMismatch: true,
+		ParentKey:              parentKey,
+		Query:                  datastore.NewQuery("testHoge").Ancestor(parentKey),
+		Stats:                  &stats,
+	}) {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		chunks++
+	}
+
+	if stats.ChunksProduced != chunks {
+		t.Fatalf("ChunksProduced differs => expected: %d, result: %d", chunks, stats.ChunksProduced)
+	}
+	if stats.EntitiesFetched != allHoges {
+		t.Fatalf("EntitiesFetched differs => expected: %d, result: %d", allHoges, stats.EntitiesFetched)
+	}
+	// the empty chunk at the end is not loaded.
+	if stats.GetMultiCalls == 0 || stats.GetMultiCalls > chunks {
+		t.Fatalf("GetMultiCalls is invalid: %d", stats.GetMultiCalls)
+	}
+	if stats.QueryDuration <= 0 || stats.TotalGetMultiDuration <= 0 {
+		t.Fatalf("durations are not recorded: %v, %v", stats.QueryDuration, stats.TotalGetMultiDuration)
+	}
+}
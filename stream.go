@@ -0,0 +1,42 @@
+//go:build go1.23
+// +build go1.23
+
+package generator
+
+import (
+	"iter"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// Stream returns an iterator that yields each entity as *T.  If Appender is
+// not set in o, it makes a new T for each key and sets the fields tagged with
+// goon:"id" and goon:"parent".  Errors are yielded as Seq does, and an entity
+// that is not *T is an error.
+//
+//	for h, err := range generator.Stream[Hoge](ctx, &generator.Options{
+//	  Query: datastore.NewQuery("Hoge"),
+//	}) {
+//	  ...
+//	}
+func Stream[T any](ctx context.Context, o *Options) iter.Seq2[*T, error] {
+	oc := typedOptions[T](o)
+
+	return func(yield func(*T, error) bool) {
+		for e, err := range Seq(ctx, oc) {
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			t, ok := e.(*T)
+			if !ok {
+				yield(nil, errors.Errorf("entity is %T, not %T", e, t))
+				return
+			}
+			if !yield(t, nil) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,56 @@
+//go:build go1.23
+// +build go1.23
+
+package generator
+
+import (
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+)
+
+func TestStream(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	q := datastore.NewQuery("testHoge").Ancestor(parentKey)
+
+	count := 0
+	for h, err := range Stream[testHoge](ctx, &Options{
+		ChunkSize:              chunkSize,
+		IgnoreErrFieldMismatch: true,
+		Query:                  q,
+	}) {
+		if err != nil {
+			t.Fatalf("error in Stream: %+v", err)
+		}
+		if h.ID == 0 || !h.Parent.Equal(parentKey) || h.Name == "" {
+			t.Fatalf("entity differs: %+v", h)
+		}
+		count++
+	}
+
+	if count != allHoges {
+		t.Fatalf("number differs => expected: %d, result: %d", allHoges, count)
+	}
+
+	// testOldHoge makes ErrFieldMismatch without IgnoreErrFieldMismatch.
+	var last error
+	for _, err := range Stream[testHoge](ctx, &Options{
+		ChunkSize: chunkSize,
+		Query:     q,
+	}) {
+		last = err
+	}
+	if last == nil {
+		t.Fatalf("ErrFieldMismatch is not yielded")
+	}
+}
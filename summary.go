@@ -0,0 +1,50 @@
+package generator
+
+import (
+	"golang.org/x/net/context"
+)
+
+// Summary has the totals of a stream.  It is yielded in the Unit of UnitDone
+// if Summary is set in Options.
+type Summary struct {
+	// Units is the number of Units that have entities.
+	Units int
+	// Entities is the number of entities in the Units.
+	Entities int
+	// Errors is the number of Units that have an error.  The stream has
+	// finished cleanly if this is 0.
+	Errors int
+}
+
+// summarize counts the Units from in and yields a Unit of UnitDone with the
+// Summary after in is closed.  The UnitDone from Heartbeat is replaced with
+// it.  Nothing is yielded at the end if ctx is done, because the stream may
+// have been cut.
+func summarize(ctx context.Context, in <-chan Unit) <-chan Unit {
+	out := make(chan Unit)
+
+	go func() {
+		defer close(out)
+
+		var s Summary
+		for u := range in {
+			switch {
+			case u.Kind == UnitDone:
+				continue
+			case u.Err != nil:
+				s.Errors++
+			case u.Kind == UnitData:
+				s.Units++
+				s.Entities += len(u.Entities)
+			}
+			out <- u
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+		out <- Unit{Kind: UnitDone, Summary: &s}
+	}()
+
+	return out
+}
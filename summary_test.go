@@ -0,0 +1,84 @@
+package generator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestSummary(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	// the UnitDone of Heartbeat is replaced with the one with Summary.
+	var units []Unit
+	for unit := range New(ctx, &Options{
+		Appender:               appender,
+		ChunkSize:              chunkSize,
+		Heartbeat:              time.Hour,
+		IgnoreErrFieldMismatch: true,
+		ParentKey:              parentKey,
+		Query:                  datastore.NewQuery("testHoge").Ancestor(parentKey),
+		Summary:                true,
+	}) {
+		units = append(units, unit)
+	}
+
+	dones := 0
+	for _, u := range units {
+		if u.Kind == UnitDone {
+			dones++
+		}
+	}
+	if dones != 1 {
+		t.Fatalf("number of UnitDone differs => expected: 1, result: %d", dones)
+	}
+	last := units[len(units)-1]
+	if last.Kind != UnitDone || last.Summary == nil {
+		t.Fatalf("last Unit does not have Summary: %+v", last)
+	}
+	expected := Summary{Units: allHoges/chunkSize + 1, Entities: allHoges}
+	if *last.Summary != expected {
+		t.Fatalf("Summary differs => expected: %+v, result: %+v", expected, *last.Summary)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	in := make(chan Unit)
+	go func() {
+		defer close(in)
+		in <- Unit{Entities: []interface{}{1, 2}}
+		in <- Unit{Err: errors.New("error"), Kind: UnitError}
+		in <- Unit{Kind: UnitHeartbeat}
+		in <- Unit{Entities: []interface{}{3}}
+	}()
+
+	var last Unit
+	for u := range summarize(context.Background(), in) {
+		last = u
+	}
+	expected := Summary{Units: 2, Entities: 3, Errors: 1}
+	if last.Kind != UnitDone || last.Summary == nil || *last.Summary != expected {
+		t.Fatalf("Summary differs => expected: %+v, result: %+v", expected, last.Summary)
+	}
+
+	// nothing is yielded at the end if the context is cancelled.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	in = make(chan Unit)
+	close(in)
+	if u, ok := <-summarize(ctx, in); ok {
+		t.Fatalf("Unit is yielded after cancel: %+v", u)
+	}
+}
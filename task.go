@@ -0,0 +1,107 @@
+package generator
+
+import (
+	"net/url"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/taskqueue"
+)
+
+// EnqueueShards splits the query of o into shards as NewSharded does, and adds
+// a POST task to path for each shard to queue, so that the instances run
+// disjoint shards.  The tasks are routed to module if it is not empty.  The
+// handler of path gets the shard with ParseShard and runs its own Options with
+// Shard.Query.  Only Query, Namespace and Goon of o are used.
+func EnqueueShards(ctx context.Context, o *Options, shards int, path, queue, module string) ([]*taskqueue.Task, error) {
+	if o == nil || o.Query == nil {
+		return nil, errors.New("Query is not set")
+	}
+	if shards < 1 {
+		return nil, errors.Errorf("invalid number of shards: %d", shards)
+	}
+
+	oc := *o
+	nctx, err := withNamespace(ctx, &oc)
+	if err != nil {
+		return nil, err
+	}
+
+	ss := []Shard{{Count: 1}}
+	if shards > 1 {
+		ss, err = makeShards(fromContext(nctx, oc.Goon), oc.Query, shards)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var host string
+	if module != "" {
+		host, err = appengine.ModuleHostname(ctx, module, "", "")
+		if err != nil {
+			return nil, errors.Wrap(err, "error in ModuleHostname")
+		}
+	}
+
+	tasks := make([]*taskqueue.Task, 0, len(ss))
+	for _, s := range ss {
+		t := taskqueue.NewPOSTTask(path, s.Values())
+		if host != "" {
+			t.Header.Set("Host", host)
+		}
+		tasks = append(tasks, t)
+	}
+
+	tasks, err = addTasks(ctx, tasks, queue)
+	if err != nil {
+		return nil, errors.Wrap(err, "error in AddMulti")
+	}
+	return tasks, nil
+}
+
+// addTasks adds tasks to queue.  This can be replaced in tests.
+var addTasks = taskqueue.AddMulti
+
+// Values returns the parameters of the shard for a task.  ParseShard reads
+// them.
+func (s Shard) Values() url.Values {
+	v := url.Values{}
+	v.Set("shard_index", strconv.Itoa(s.Index))
+	v.Set("shard_count", strconv.Itoa(s.Count))
+	if s.Start != nil {
+		v.Set("shard_start", s.Start.Encode())
+	}
+	if s.End != nil {
+		v.Set("shard_end", s.End.Encode())
+	}
+	return v
+}
+
+// ParseShard returns the shard from the parameters made by Shard.Values.
+func ParseShard(v url.Values) (Shard, error) {
+	var s Shard
+	var err error
+	if s.Index, err = strconv.Atoi(v.Get("shard_index")); err != nil {
+		return Shard{}, errors.Wrap(err, "invalid shard_index")
+	}
+	if s.Count, err = strconv.Atoi(v.Get("shard_count")); err != nil {
+		return Shard{}, errors.Wrap(err, "invalid shard_count")
+	}
+	if s.Index < 0 || s.Index >= s.Count {
+		return Shard{}, errors.Errorf("shard_index %d is out of shard_count %d", s.Index, s.Count)
+	}
+	if k := v.Get("shard_start"); k != "" {
+		if s.Start, err = datastore.DecodeKey(k); err != nil {
+			return Shard{}, errors.Wrap(err, "invalid shard_start")
+		}
+	}
+	if k := v.Get("shard_end"); k != "" {
+		if s.End, err = datastore.DecodeKey(k); err != nil {
+			return Shard{}, errors.Wrap(err, "invalid shard_end")
+		}
+	}
+	return s, nil
+}
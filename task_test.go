@@ -0,0 +1,106 @@
+package generator
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/mjibson/goon"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/taskqueue"
+)
+
+func TestEnqueueShards(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	// the test datastore does not have __scatter__, so all keys are samples.
+	origSampleKeys := sampleKeys
+	defer func() { sampleKeys = origSampleKeys }()
+	sampleKeys = func(g *goon.Goon, q *datastore.Query, n int) ([]*datastore.Key, error) {
+		return q.KeysOnly().GetAll(g.Context, nil)
+	}
+
+	origAddTasks := addTasks
+	defer func() { addTasks = origAddTasks }()
+	var added []*taskqueue.Task
+	var queues []string
+	addTasks = func(ctx context.Context, tasks []*taskqueue.Task, queue string) ([]*taskqueue.Task, error) {
+		added = append(added, tasks...)
+		queues = append(queues, queue)
+		return tasks, nil
+	}
+
+	q := datastore.NewQuery("testHoge").Ancestor(parentKey)
+	tasks, err := EnqueueShards(ctx, &Options{Query: q}, 4, "/scan", "scan", "backend")
+	if err != nil {
+		t.Fatalf("error in EnqueueShards: %+v", err)
+	}
+
+	if len(tasks) != 4 || len(added) != 4 {
+		t.Fatalf("number of tasks differs => expected: 4, result: %d, added: %d", len(tasks), len(added))
+	}
+	if len(queues) != 1 || queues[0] != "scan" {
+		t.Fatalf("queues differ: %v", queues)
+	}
+
+	keys := map[string]int{}
+	for i, task := range added {
+		if task.Path != "/scan" {
+			t.Fatalf("path differs => expected: /scan, result: %s", task.Path)
+		}
+		if host := task.Header.Get("Host"); !strings.HasPrefix(host, "backend.") {
+			t.Fatalf("task is not routed to the module: %s", host)
+		}
+		v, err := url.ParseQuery(string(task.Payload))
+		if err != nil {
+			t.Fatalf("error in ParseQuery: %+v", err)
+		}
+		s, err := ParseShard(v)
+		if err != nil {
+			t.Fatalf("error in ParseShard: %+v", err)
+		}
+		if s.Index != i || s.Count != 4 {
+			t.Fatalf("shard differs => expected: %d/4, result: %d/%d", i, s.Index, s.Count)
+		}
+
+		ks, err := s.Query(q).KeysOnly().GetAll(ctx, nil)
+		if err != nil {
+			t.Fatalf("error in GetAll: %+v", err)
+		}
+		for _, k := range ks {
+			keys[k.Encode()]++
+		}
+	}
+
+	if len(keys) != allHoges+1 {
+		t.Fatalf("number of keys differs => expected: %d, result: %d", allHoges+1, len(keys))
+	}
+	for k, n := range keys {
+		if n != 1 {
+			t.Fatalf("key is in %d shards: %s", n, k)
+		}
+	}
+}
+
+func TestParseShard(t *testing.T) {
+	for _, v := range []url.Values{
+		{},
+		{"shard_index": {"1"}},
+		{"shard_index": {"4"}, "shard_count": {"4"}},
+		{"shard_index": {"0"}, "shard_count": {"4"}, "shard_end": {"invalid"}},
+	} {
+		if _, err := ParseShard(v); err == nil {
+			t.Fatalf("error is not returned for %v", v)
+		}
+	}
+}
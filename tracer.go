@@ -0,0 +1,69 @@
+package generator
+
+import (
+	"golang.org/x/net/context"
+)
+
+// Tracer starts spans for the stages of the generator.  It can be backed by
+// OpenCensus or OpenTelemetry, so that the spans nest under the span in the
+// context given to New.
+type Tracer interface {
+	StartSpan(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a span started by Tracer.
+type Span interface {
+	SetAttribute(key string, value interface{})
+	End()
+}
+
+// noopSpan is the Span used when Tracer is not set.
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(key string, value interface{}) {}
+func (noopSpan) End()                                       {}
+
+// startSpan starts a span with Tracer in o.  It returns ctx as it is and a
+// noopSpan if Tracer is not set.
+func startSpan(ctx context.Context, o *Options, name string) (context.Context, Span) {
+	if o.Tracer == nil {
+		return ctx, noopSpan{}
+	}
+	return o.Tracer.StartSpan(ctx, name)
+}
+
+// startChunkSpan starts a span for the chunk of meta if it is sampled.  The
+// Units without meta are always traced.
+func startChunkSpan(ctx context.Context, o *Options, meta *chunkMeta, name string) (context.Context, Span) {
+	if meta != nil && !meta.traced {
+		return ctx, noopSpan{}
+	}
+	return startSpan(ctx, o, name)
+}
+
+// sampled tells whether the chunk at index is traced with TraceSampleRate.
+// One in every 1/TraceSampleRate chunks is traced, so the fraction is kept
+// without randomness.
+func sampled(o *Options, index int) bool {
+	r := o.TraceSampleRate
+	if r <= 0 || r >= 1 {
+		return true
+	}
+	return int(float64(index+1)*r) > int(float64(index)*r)
+}
+
+// endSpan yields Units from in and ends span after in is closed.
+func endSpan(in <-chan Unit, span Span) <-chan Unit {
+	out := make(chan Unit)
+
+	go func() {
+		defer close(out)
+		defer span.End()
+
+		for u := range in {
+			out <- u
+		}
+	}()
+
+	return out
+}
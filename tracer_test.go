@@ -0,0 +1,137 @@
+package generator
+
+import (
+	"sync"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+type testTracer struct {
+	mu    sync.Mutex
+	spans []*testSpan
+}
+
+type testSpan struct {
+	name  string
+	attrs map[string]interface{}
+	ended int
+}
+
+func (t *testTracer) StartSpan(ctx context.Context, name string) (context.Context, Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := &testSpan{name: name, attrs: map[string]interface{}{}}
+	t.spans = append(t.spans, s)
+	return ctx, &testTracerSpan{t, s}
+}
+
+// testTracerSpan records to testSpan under the lock of the tracer.
+type testTracerSpan struct {
+	t *testTracer
+	s *testSpan
+}
+
+func (s *testTracerSpan) SetAttribute(key string, value interface{}) {
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+	s.s.attrs[key] = value
+}
+
+func (s *testTracerSpan) End() {
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+	s.s.ended++
+}
+
+func TestTracer(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	tracer := &testTracer{}
+	chunks := 0
+	for unit := range New(ctx, &Options{
+		Appender:               appender,
+		ChunkSize:              chunkSize,
+		IgnoreErrFieldMismatch: true,
+		ParentKey:              parentKey,
+		Query:                  datastore.NewQuery("testHoge").Ancestor(parentKey),
+		Tracer:                 tracer,
+	}) {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		chunks++
+	}
+
+	counts := map[string]int{}
+	for _, s := range tracer.spans {
+		if s.ended != 1 {
+			t.Fatalf("span %s is ended %d times", s.name, s.ended)
+		}
+		if _, ok := s.attrs["chunk"]; !ok && s.name != "generator.Run" {
+			t.Fatalf("span %s does not have chunk", s.name)
+		}
+		counts[s.name]++
+	}
+
+	// the empty chunk at the end is not loaded.
+	if counts["generator.Run"] != 1 || counts["generator.query"] != chunks || counts["generator.GetMulti"] == 0 || counts["generator.GetMulti"] > chunks {
+		t.Fatalf("spans differ: %v", counts)
+	}
+}
+
+func TestTraceSampleRate(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	tracer := &testTracer{}
+	chunks := 0
+	for unit := range New(ctx, &Options{
+		Appender:               appender,
+		ChunkSize:              1,
+		IgnoreErrFieldMismatch: true,
+		ParentKey:              parentKey,
+		Query:                  datastore.NewQuery("testHoge").Ancestor(parentKey),
+		TraceSampleRate:        0.5,
+		Tracer:                 tracer,
+	}) {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		chunks++
+	}
+
+	counts := map[string]int{}
+	for _, s := range tracer.spans {
+		counts[s.name]++
+	}
+
+	// the span for the run is not sampled.
+	if counts["generator.Run"] != 1 {
+		t.Fatalf("span for the run differs: %v", counts)
+	}
+	if n := counts["generator.query"]; n != chunks/2 {
+		t.Fatalf("number of traced chunks differs => expected: %d, result: %d", chunks/2, n)
+	}
+	if n := counts["generator.GetMulti"]; n == 0 || n > counts["generator.query"] {
+		t.Fatalf("number of GetMulti spans differs: %v", counts)
+	}
+}
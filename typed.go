@@ -0,0 +1,68 @@
+//go:build go1.18
+// +build go1.18
+
+package generator
+
+import (
+	"reflect"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// TypedUnit is a Unit that has entities as *T.
+type TypedUnit[T any] struct {
+	Entities []*T
+	Err      error
+}
+
+// NewTyped returns a channel like New that yields entities as *T.  If Appender
+// is not set in o, it makes a new T for each key and sets the fields tagged
+// with goon:"id" and goon:"parent".  An entity that is not *T is yielded as an
+// error, and the generator stops then.
+func NewTyped[T any](ctx context.Context, o *Options) <-chan TypedUnit[T] {
+	out := make(chan TypedUnit[T])
+
+	ctx, cancel := context.WithCancel(ctx)
+	ch := New(ctx, typedOptions[T](o))
+
+	go func() {
+		defer close(out)
+		defer func() {
+			cancel()
+			Drain(ch)
+		}()
+
+		for unit := range ch {
+			if unit.Err != nil {
+				out <- TypedUnit[T]{Err: unit.Err}
+				continue
+			}
+
+			tu := TypedUnit[T]{Entities: make([]*T, 0, len(unit.Entities))}
+			for _, e := range unit.Entities {
+				t, ok := e.(*T)
+				if !ok {
+					out <- TypedUnit[T]{Err: errors.Errorf("entity is %T, not %T", e, t)}
+					return
+				}
+				tu.Entities = append(tu.Entities, t)
+			}
+			out <- tu
+		}
+	}()
+
+	return out
+}
+
+// typedOptions returns a copy of o with the default Appender for T.
+func typedOptions[T any](o *Options) *Options {
+	var oc Options
+	if o != nil {
+		oc = *o
+	}
+	if oc.Appender == nil {
+		oc.Appender = entityAppender(reflect.TypeOf((*T)(nil)).Elem())
+	}
+	return &oc
+}
@@ -0,0 +1,71 @@
+//go:build go1.18
+// +build go1.18
+
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// testAnotherHoge has the same properties as testHoge.  ID is saved as a
+// property in testHoge.
+type testAnotherHoge struct {
+	_kind  string         `goon:"kind,testHoge"`
+	ID     int64          `goon:"id"`
+	Parent *datastore.Key `datastore:"-" goon:"parent"`
+	Name   string
+}
+
+func TestNewTyped(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	q := datastore.NewQuery("testHoge").Ancestor(parentKey).Filter("Name =", "Fuga Hogeo")
+
+	count := 0
+	for unit := range NewTyped[testHoge](ctx, &Options{
+		ChunkSize: chunkSize,
+		Query:     q,
+	}) {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		for _, h := range unit.Entities {
+			if h.ID == 0 || !h.Parent.Equal(parentKey) || h.Name != "Fuga Hogeo" {
+				t.Fatalf("entity differs: %+v", h)
+			}
+			count++
+		}
+	}
+
+	if count != allFugas {
+		t.Fatalf("number differs => expected: %d, result: %d", allFugas, count)
+	}
+
+	// Appender makes another type.
+	var last error
+	for unit := range NewTyped[testHoge](ctx, &Options{
+		Appender: func(ctx context.Context, entities []interface{}, i int, k *datastore.Key, parentKey *datastore.Key) []interface{} {
+			return append(entities, &testAnotherHoge{ID: k.IntID(), Parent: k.Parent()})
+		},
+		ChunkSize: chunkSize,
+		Query:     q,
+	}) {
+		last = unit.Err
+	}
+	if last == nil || !strings.Contains(last.Error(), "testAnotherHoge") {
+		t.Fatalf("error for another type differs: %v", last)
+	}
+}
@@ -0,0 +1,72 @@
+package generator
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// ValidateAppender checks Appender in o with sampleSize keys of the query.  It
+// returns an error if Appender makes entities whose keys differ from the
+// scanned ones, or if they cannot be loaded.  The query runs in Namespace.
+// Errors are ignored by IgnoreErrFieldMismatch and IgnoreErrNoSuchEntity.
+// Appender made from EntityType is checked as well.  With KeysOnly, no
+// Appender is used, so it checks only that the query runs.
+func ValidateAppender(ctx context.Context, o *Options, sampleSize int) error {
+	if o == nil || o.Appender == nil && o.EntityType == nil && !o.KeysOnly {
+		return errors.New("Appender is not set")
+	}
+	if o.Query == nil {
+		return errors.New("Query is not set")
+	}
+	if sampleSize <= 0 {
+		return errors.Errorf("invalid sample size: %d", sampleSize)
+	}
+
+	oc := *o
+	o = withDefaults(ctx, &oc)
+	ctx, err := withNamespace(ctx, o)
+	if err != nil {
+		return err
+	}
+
+	g := fromContext(ctx, o.Goon)
+	t := g.Run(o.Query.KeysOnly().Limit(sampleSize))
+
+	var entities []interface{}
+	for i := 0; ; i++ {
+		k, err := t.Next(nil)
+		if err == datastore.Done {
+			break
+		} else if err != nil {
+			return errors.Wrap(err, "error in Next")
+		}
+		if o.KeysOnly {
+			continue
+		}
+
+		n := len(entities)
+		entities = o.Appender(ctx, entities, i, k, o.ParentKey)
+		for j := n; j < len(entities); j++ {
+			ek, err := g.KeyError(entities[j])
+			if err != nil {
+				return errors.Wrapf(err, "Appender makes an invalid entity %T for key %v", entities[j], k)
+			}
+			if !ek.Equal(k) {
+				return errors.Errorf("Appender makes %T with key %v for key %v", entities[j], ek, k)
+			}
+		}
+	}
+
+	if len(entities) == 0 {
+		return nil
+	}
+
+	if err := g.GetMulti(entities); err != nil {
+		if _, err := ignoreErrors(ctx, entities, err, o); err != nil {
+			return errors.Wrap(err, "error in GetMulti")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package generator
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestValidateAppender(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	q := datastore.NewQuery("testHoge").Ancestor(parentKey)
+	if err := ValidateAppender(ctx, &Options{
+		Appender:  appender,
+		ParentKey: parentKey,
+		Query:     q,
+	}, 10); err != nil {
+		t.Fatalf("error in ValidateAppender: %+v", err)
+	}
+
+	if err := ValidateAppender(ctx, &Options{
+		EntityType:             reflect.TypeOf(testHoge{}),
+		IgnoreErrFieldMismatch: true,
+		Query:                  q,
+	}, 10); err != nil {
+		t.Fatalf("error in ValidateAppender with EntityType: %+v", err)
+	}
+	if err := ValidateAppender(ctx, &Options{KeysOnly: true, Query: q}, 10); err != nil {
+		t.Fatalf("error in ValidateAppender with KeysOnly: %+v", err)
+	}
+	if err := ValidateAppender(ctx, &Options{Query: q}, 10); err == nil {
+		t.Fatalf("error is not returned without Appender")
+	}
+
+	// testParent has another kind.
+	err = ValidateAppender(ctx, &Options{
+		Appender: func(ctx context.Context, entities []interface{}, i int, k *datastore.Key, parentKey *datastore.Key) []interface{} {
+			return append(entities, &testParent{ID: k.IntID()})
+		},
+		ParentKey: parentKey,
+		Query:     q,
+	}, 10)
+	if err == nil || !strings.Contains(err.Error(), "testParent") {
+		t.Fatalf("error for another kind differs: %v", err)
+	}
+
+	// non-pointer entities cannot be loaded.
+	err = ValidateAppender(ctx, &Options{
+		Appender: func(ctx context.Context, entities []interface{}, i int, k *datastore.Key, parentKey *datastore.Key) []interface{} {
+			return append(entities, k.IntID())
+		},
+		ParentKey: parentKey,
+		Query:     q,
+	}, 10)
+	if err == nil || !strings.Contains(err.Error(), "int64") {
+		t.Fatalf("error for non-struct differs: %v", err)
+	}
+}
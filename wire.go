@@ -0,0 +1,97 @@
+package generator
+
+import (
+	"encoding/gob"
+	"io"
+
+	"github.com/pkg/errors"
+	"google.golang.org/appengine/datastore"
+)
+
+// wireUnit is a Unit on the wire.  Err is sent as its message.
+type wireUnit struct {
+	Entities []interface{}
+	Err      string
+	Keys     []*datastore.Key
+	Kinds    []string
+	ChunkID  string
+	Bucket   string
+	Cursor   string
+	Kind     UnitKind
+	Seq      int
+	Summary  *Summary
+	Attempts int
+}
+
+// EncodeUnits writes Units from in to w with gob until in is closed.  The
+// types of entities must be registered with gob.Register on both sides.  The
+// error in Unit is sent as its message.  It returns the first error in
+// writing, and in is drained then.
+func EncodeUnits(w io.Writer, in <-chan Unit) error {
+	enc := gob.NewEncoder(w)
+	for u := range in {
+		wu := wireUnit{
+			Entities: u.Entities,
+			Keys:     u.Keys,
+			Kinds:    u.Kinds,
+			ChunkID:  u.ChunkID,
+			Bucket:   u.Bucket,
+			Cursor:   u.Cursor,
+			Kind:     u.Kind,
+			Seq:      u.Seq,
+			Summary:  u.Summary,
+			Attempts: u.Attempts,
+		}
+		if u.Err != nil {
+			wu.Err = u.Err.Error()
+		}
+
+		if err := enc.Encode(&wu); err != nil {
+			Drain(in)
+			return errors.Wrap(err, "error in Encode")
+		}
+	}
+
+	return nil
+}
+
+// DecodeUnits returns a channel that yields Units read from r written by
+// EncodeUnits.  The channel is closed at the end of r.  A Unit with the error
+// is yielded at last if it fails to read.
+func DecodeUnits(r io.Reader) <-chan Unit {
+	out := make(chan Unit)
+
+	go func() {
+		defer close(out)
+
+		dec := gob.NewDecoder(r)
+		for {
+			var wu wireUnit
+			if err := dec.Decode(&wu); err == io.EOF {
+				return
+			} else if err != nil {
+				out <- Unit{Err: errors.Wrap(err, "error in Decode"), Kind: UnitError}
+				return
+			}
+
+			u := Unit{
+				Entities: wu.Entities,
+				Keys:     wu.Keys,
+				Kinds:    wu.Kinds,
+				ChunkID:  wu.ChunkID,
+				Bucket:   wu.Bucket,
+				Cursor:   wu.Cursor,
+				Kind:     wu.Kind,
+				Seq:      wu.Seq,
+				Summary:  wu.Summary,
+				Attempts: wu.Attempts,
+			}
+			if wu.Err != "" {
+				u.Err = errors.New(wu.Err)
+			}
+			out <- u
+		}
+	}()
+
+	return out
+}
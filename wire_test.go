@@ -0,0 +1,78 @@
+package generator
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+)
+
+func TestEncodeUnits(t *testing.T) {
+	ctx, cancel, err := testServer()
+	if err != nil {
+		t.Fatalf("error in testServer: %+v", err)
+	}
+	defer cancel()
+
+	parentKey, err := createSampleHoge(ctx)
+	if err != nil {
+		t.Fatalf("error in createSampleHoge: %+v", err)
+	}
+
+	gob.Register(&testHoge{})
+
+	o := &Options{
+		Appender:  appender,
+		ChunkSize: chunkSize,
+		ParentKey: parentKey,
+		Query:     datastore.NewQuery("testHoge").Ancestor(parentKey).Filter("Name =", "Fuga Hogeo"),
+		Sequence:  true,
+	}
+
+	var expected []*testHoge
+	var cursors []string
+	for unit := range New(ctx, o) {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		cursors = append(cursors, unit.Cursor)
+		for _, e := range unit.Entities {
+			expected = append(expected, e.(*testHoge))
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeUnits(&buf, New(ctx, o)); err != nil {
+		t.Fatalf("error in EncodeUnits: %+v", err)
+	}
+
+	i := 0
+	j := 0
+	for unit := range DecodeUnits(&buf) {
+		if unit.Err != nil {
+			t.Fatalf("error in unit: %+v", unit.Err)
+		}
+		if unit.Cursor == "" || unit.Cursor != cursors[j] {
+			t.Fatalf("Cursor differs => expected: %s, result: %s", cursors[j], unit.Cursor)
+		}
+		j++
+		if unit.Seq != i {
+			t.Fatalf("Seq differs => expected: %d, result: %d", i, unit.Seq)
+		}
+		for _, e := range unit.Entities {
+			h, ok := e.(*testHoge)
+			if !ok {
+				t.Fatalf("type differs: %T", e)
+			}
+			if h.ID != expected[i].ID || h.Name != expected[i].Name || !h.Parent.Equal(expected[i].Parent) {
+				t.Fatalf("entity differs => expected: %+v, result: %+v", expected[i], h)
+			}
+			i++
+		}
+	}
+
+	if i != len(expected) {
+		t.Fatalf("number differs => expected: %d, result: %d", len(expected), i)
+	}
+}